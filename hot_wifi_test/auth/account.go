@@ -1,38 +1,124 @@
 package auth
 
 import (
-	"time"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+	"strings"
+	"time"
 )
 
-type Account struct {
-	ID                *primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Login             string              `json:"login",bson:"login"`
-	PasswordHash      string              `bson:"password_hash"`
-	Password          string              `json:"password"`
-	PasswordCreated   int64               `bson:"password_created"`
-	IsExternalAccount bool                `json:"isExternalAccount",bson:"is_external_account"`
+var loginRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._@-]*$`)
+
+// NormalizeLogin folds case and drops spaces around login, so logins which differ only by
+// case are same. Logins of new accounts must also match loginRe after normalisation.
+func NormalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
 }
 
-func (a *Account) IsSupervisor() bool {
-	return a.Login == SUPERVISOR_LOGIN
+// Account is stored account record. It never holds cleartext password, only its hash.
+type Account struct {
+	ID                *primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Login             string              `json:"login" bson:"login"`
+	PasswordHash      string              `json:"-" bson:"password_hash"`
+	PasswordCreated   int64               `json:"-" bson:"password_created"`
+	PasswordHistory   []string            `json:"-" bson:"password_history"`
+	IsExternalAccount bool                `json:"isExternalAccount" bson:"isExternalAccount"`
+	Roles             []string            `json:"roles,omitempty" bson:"roles"`
+	// MustChangePassword is set when password was reset by administrator.
+	MustChangePassword bool `json:"mustChangePassword" bson:"must_change_password"`
+	// TOTPPending is secret of enrolment which is not confirmed yet, TOTPLastStep is
+	// time step of last accepted code, RecoveryCodes are hashes of unused codes.
+	TOTPEnabled   bool     `json:"totpEnabled" bson:"totp_enabled"`
+	TOTPSecret    string   `json:"-" bson:"totp_secret"`
+	TOTPPending   string   `json:"-" bson:"totp_pending"`
+	TOTPLastStep  int64    `json:"-" bson:"totp_last_step"`
+	RecoveryCodes []string `json:"-" bson:"recovery_codes"`
+	// ExternalProvider is name of identity provider which authenticates account and
+	// ExternalSubject is id of user there.
+	ExternalProvider string `json:"externalProvider,omitempty" bson:"external_provider"`
+	ExternalSubject  string `json:"-" bson:"external_subject"`
+	// IdempotencyKey is key of request which created account, retry with it gets same account.
+	// IdempotencyHash is keyed hash of that request, retry must have the same body.
+	IdempotencyKey  string `json:"-" bson:"idempotency_key,omitempty"`
+	IdempotencyHash string `json:"-" bson:"idempotency_hash,omitempty"`
+	// Version is incremented at every update, see UpdateAccount.
+	Version int64 `json:"version" bson:"version"`
 }
 
-func (a *Account) IsPasswordExpire() bool {
-	if !a.IsSupervisor() && !a.IsExternalAccount && (a.PasswordCreated+int64(PASSWORD_TTL) < time.Now().Unix()) {
+// IsPasswordExpired reports that password is older than maxAge seconds or was reset
+// and must be changed. Passwords of external accounts never expire.
+func (a *Account) IsPasswordExpired(maxAge int64) bool {
+	if a.MustChangePassword {
+		return true
+	}
+	if a.IsExternalAccount {
 		return false
 	}
-	return true
+	return a.PasswordCreated+maxAge < time.Now().Unix()
 }
 
-func (a *Account) SetNewPassword(new string) {
-	a.Password = new
-	a.PasswordHash = CreateHash(new)
+// maxPasswordHistory is count of previous password hashes kept for policy history check.
+const maxPasswordHistory = 24
+
+func (a *Account) SetNewPassword(hashing *PasswordHashing, new string) error {
+	hash, err := hashing.Hash(new)
+	if err != nil {
+		return err
+	}
+	if a.PasswordHash != "" {
+		a.PasswordHistory = append([]string{a.PasswordHash}, a.PasswordHistory...)
+		if len(a.PasswordHistory) > maxPasswordHistory {
+			a.PasswordHistory = a.PasswordHistory[:maxPasswordHistory]
+		}
+	}
+	a.PasswordHash = hash
 	a.PasswordCreated = time.Now().Unix()
+	a.MustChangePassword = false
+	return nil
+}
+
+// CheckPassword verifies password against stored hash. If hash was made by outdated
+// hasher or parameters it is replaced with new one, caller must save account then.
+func (a *Account) CheckPassword(hashing *PasswordHashing, password string) (ok bool, upgraded bool, err error) {
+	if a.PasswordHash == "" {
+		return false, false, nil
+	}
+	ok, rehash, err := hashing.Verify(password, a.PasswordHash)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	if rehash {
+		hash, err := hashing.Hash(password)
+		if err != nil {
+			return true, false, err
+		}
+		a.PasswordHash = hash
+		return true, true, nil
+	}
+	return true, false, nil
 }
 
 type AccountView struct {
 	ID                *primitive.ObjectID `json:"id" bson:"_id"`
-	Login             string              `json:"login",bson:"login"`
-	IsExternalAccount bool                `json:"isExternalAccount",bson:"isExternalAccount"`
+	Login             string              `json:"login" bson:"login"`
+	IsExternalAccount bool                `json:"isExternalAccount" bson:"isExternalAccount"`
+	Roles             []string            `json:"roles,omitempty" bson:"roles"`
+}
+
+// AccountCreateData is request for creating account.
+type AccountCreateData struct {
+	Login             string `json:"login"`
+	Password          string `json:"password"`
+	IsExternalAccount bool   `json:"isExternalAccount"`
+}
+
+func (d *AccountCreateData) validateFields() []FieldError {
+	fe := fieldErrors{}
+	fe.login("login", d.Login)
+	if d.IsExternalAccount {
+		fe.check(d.Password == "", "password", "must be empty for external account")
+	} else {
+		fe.password("password", d.Password)
+	}
+	return fe
 }
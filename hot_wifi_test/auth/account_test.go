@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAccountDoesNotKeepPassword(t *testing.T) {
+	acc := Account{Login: "user"}
+	acc.SetNewPassword(hashing, "cleartextSecret1")
+
+	data, _ := bson.Marshal(&acc)
+	var doc bson.M
+	bson.Unmarshal(data, &doc)
+	if _, ok := doc["password"]; ok {
+		t.Errorf("stored document must not have password field: %v", doc)
+	}
+	for key, value := range doc {
+		if s, ok := value.(string); ok && strings.Contains(s, "cleartextSecret1") {
+			t.Errorf("stored document has cleartext password in %s", key)
+		}
+	}
+
+	data, _ = json.Marshal(&acc)
+	if strings.Contains(string(data), "cleartextSecret1") || strings.Contains(string(data), acc.PasswordHash) {
+		t.Errorf("json of account must not have password or its hash: %s", data)
+	}
+}
+
+func TestPasswordExpiry(t *testing.T) {
+	now := time.Now().Unix()
+	maxAge := int64(cfg.PasswordTTL)
+	fresh := Account{Login: "user", PasswordCreated: now}
+	if fresh.IsPasswordExpired(maxAge) {
+		t.Errorf("fresh password must not be expired")
+	}
+
+	old := Account{Login: "user", PasswordCreated: now - int64(cfg.PasswordTTL) - 1}
+	if !old.IsPasswordExpired(maxAge) {
+		t.Errorf("old password must be expired")
+	}
+
+	external := Account{Login: "user", PasswordCreated: old.PasswordCreated, IsExternalAccount: true}
+	if external.IsPasswordExpired(maxAge) {
+		t.Errorf("passwords of external accounts must not expire")
+	}
+	supervisor := Account{Login: cfg.Supervisor.Login, PasswordCreated: old.PasswordCreated}
+	if sh.isPasswordExpired(&supervisor, &PasswordPolicy{}) || !sh.isPasswordExpired(&old, &PasswordPolicy{}) {
+		t.Errorf("password of supervisor account must not expire")
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is record of one request. Actor is login of caller (or login which was
+// tried at login), Target is login, role or other object the request was about.
+type AuditEvent struct {
+	Time    time.Time `json:"time" bson:"time"`
+	Actor   string    `json:"actor" bson:"actor"`
+	Target  string    `json:"target" bson:"target"`
+	Action  string    `json:"action" bson:"action"`
+	Outcome string    `json:"outcome" bson:"outcome"`
+	Status  int       `json:"status" bson:"status"`
+	IP      string    `json:"ip" bson:"ip"`
+}
+
+// AuditFilter selects events, empty fields match any. Events are returned newest first.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+}
+
+func (f *AuditFilter) Match(e *AuditEvent) bool {
+	return (f.Actor == "" || f.Actor == e.Actor) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || e.Time.Before(f.To))
+}
+
+type auditKey struct{}
+
+// statusRecorder remembers status of response. Status may be set after body is written.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	sr.status = statusCode
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sr *statusRecorder) Status() int {
+	if sr.status == 0 {
+		return 200
+	}
+	return sr.status
+}
+
+// auditEvent returns event of request which handlers and middlewares fill with actor and target.
+func auditEvent(r *http.Request) *AuditEvent {
+	if event, ok := r.Context().Value(auditKey{}).(*AuditEvent); ok {
+		return event
+	}
+	return &AuditEvent{}
+}
+
+// Audited saves event of action for every request with outcome by response status.
+func Audited(storage AuditStorage, action string, next HttpHandlerFunc) HttpHandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		event := &AuditEvent{Action: action, IP: ClientIP(req)}
+		recorder := &statusRecorder{ResponseWriter: res}
+		next(recorder, req.WithContext(context.WithValue(req.Context(), auditKey{}, event)))
+
+		event.Time = time.Now()
+		event.Status = recorder.Status()
+		event.Outcome = AuditSuccess
+		if event.Status >= 400 {
+			event.Outcome = AuditFailure
+		}
+		err := storage.AddEvent(context.WithoutCancel(req.Context()), event)
+		if err != nil {
+			log.Printf("Error at save audit event %s of %s: %s", action, event.Actor, err)
+		}
+	}
+}
@@ -1,61 +1,145 @@
 package auth
 
 import (
-	"crypto/sha1"
+	"context"
 	"encoding/hex"
-	"fmt"
-	"time"
 	"net/http"
-	"errors"
+	"time"
 )
 
-//TODO use https://github.com/opinary/jwt
-
+// Session is server side record of login. Access token is JWT with session id as jti,
+// deleting session revokes its tokens before they expire.
+// Every login makes new independent session, so account may have many of them.
 type Session struct {
-	Login string `bson:"login"`
-	Token string `json:"auth-token",bson:"token"`
+	ID        string    `json:"id" bson:"sid"`
+	Login     string    `json:"login" bson:"login"`
+	Created   time.Time `json:"created" bson:"created"`
+	LastSeen  time.Time `json:"lastSeen" bson:"last_seen"`
+	UserAgent string    `json:"userAgent" bson:"user_agent"`
+	IP        string    `json:"ip" bson:"ip"`
+	// Token is signed access token and RefreshToken is current refresh token, they are never stored.
+	Token        string `json:"-" bson:"-"`
+	RefreshToken string `json:"-" bson:"-"`
 }
 
+// lastSeenPrecision limits writes of session last seen time to one per interval.
+const lastSeenPrecision = time.Minute
+
 type AuthManager struct {
-	sessionsStorage *SessionsStorage
-	accountsStorage *AccountsStorage
+	config               *Config
+	sessionsStorage      SessionsStorage
+	refreshTokensStorage RefreshTokensStorage
+	accountsStorage      AccountsStorage
+	rolesStorage         RolesStorage
+	tokens               *TokenIssuer
+	now                  func() time.Time
+}
+
+func NewAuthManager(config *Config, sessionsStorage SessionsStorage, refreshTokensStorage RefreshTokensStorage, accountsStorage AccountsStorage, rolesStorage RolesStorage, tokens *TokenIssuer) *AuthManager {
+	return &AuthManager{
+		config:               config,
+		sessionsStorage:      sessionsStorage,
+		refreshTokensStorage: refreshTokensStorage,
+		accountsStorage:      accountsStorage,
+		rolesStorage:         rolesStorage,
+		tokens:               tokens,
+		now:                  time.Now,
+	}
+}
+
+// FromToken validates token signature locally and checks that its session was not revoked.
+func (a *AuthManager) FromToken(ctx context.Context, token string) (*Account, error) {
+	acc, _, err := a.SessionFromToken(ctx, token)
+	return acc, err
+}
+
+func (a *AuthManager) SessionFromToken(ctx context.Context, token string) (*Account, *Session, error) {
+	claims, err := a.tokens.Parse(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	sess, err := a.sessionsStorage.GetSession(ctx, claims.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sess == nil || sess.Login != claims.Login {
+		return nil, nil, nil
+	}
+	acc, err := a.accountsStorage.GetAccount(ctx, sess.Login)
+	if err != nil || acc == nil {
+		return nil, nil, err
+	}
+	now := a.now()
+	if now.Sub(sess.LastSeen) > lastSeenPrecision {
+		sess.LastSeen = now
+		a.sessionsStorage.TouchSession(ctx, sess.ID, now)
+	}
+	return acc, sess, nil
+}
+
+func generateToken() (string, error) {
+	b, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
-func (a *AuthManager) FromToken(token string) (*Account, error) {
-	sess, err := a.sessionsStorage.GetSession(token)
+func (a *AuthManager) Login(ctx context.Context, account *Account, userAgent, ip string) (*Session, error) {
+	id, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	now := a.now()
+	s := Session{ID: id, Login: account.Login, Created: now, LastSeen: now, UserAgent: userAgent, IP: ip}
+	s.Token, err = a.tokens.Issue(account, s.ID)
 	if err != nil {
 		return nil, err
 	}
-	if sess == nil {
-		return nil, nil
+	err = a.sessionsStorage.SetSession(ctx, &s)
+	if err != nil {
+		return nil, err
 	}
-	acc, err := a.accountsStorage.GetAccount(sess.Login)
+	s.RefreshToken, err = a.issueRefreshToken(ctx, &s)
 	if err != nil {
 		return nil, err
 	}
-	return acc, nil
+	return &s, nil
 }
 
-func CreateHash(input string) string {
-	h := sha1.New()
-	h.Write([]byte(input))
-	sha1_hash := hex.EncodeToString(h.Sum(nil))
-	return sha1_hash
+// Logout revokes all sessions of login.
+func (a *AuthManager) Logout(ctx context.Context, login string) error {
+	return a.sessionsStorage.DeleteSessions(ctx, login)
 }
 
-func (a *AuthManager) Login(account *Account) (*Session, error) {
-	token := CreateHash(fmt.Sprintf("%s %s %v %v", account.Login, account.Password, account.PasswordCreated, time.Now().Unix()))
-	s := Session{Login: account.Login, Token: token}
-	a.sessionsStorage.SetSession(&s)
-	return &s, nil
+func (a *AuthManager) Sessions(ctx context.Context, login string) ([]Session, error) {
+	return a.sessionsStorage.GetSessions(ctx, login)
 }
 
-func (a *AuthManager) Logout(login string) error {
-	return a.sessionsStorage.DeleteSession(login)
+// Revoke deletes one session of login. It returns false if login has not such session.
+func (a *AuthManager) Revoke(ctx context.Context, login, id string) (bool, error) {
+	sess, err := a.sessionsStorage.GetSession(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if sess == nil || sess.Login != login {
+		return false, nil
+	}
+	return true, a.revokeSession(ctx, id)
+}
+
+// revokeSession deletes session and refresh tokens of its family.
+func (a *AuthManager) revokeSession(ctx context.Context, id string) error {
+	err := a.sessionsStorage.DeleteSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.refreshTokensStorage.DeleteRefreshTokens(ctx, id)
 }
 
 type HttpHandlerFunc func(http.ResponseWriter, *http.Request)
 type HttpHandlerFuncWithAcc func(http.ResponseWriter, *http.Request, *Account)
+type HttpHandlerFuncWithSession func(http.ResponseWriter, *http.Request, *Account, *Session)
 
 type AuthMiddleWare struct {
 	manager *AuthManager
@@ -63,23 +147,30 @@ type AuthMiddleWare struct {
 
 func (a *AuthMiddleWare) MustBeLoggedIn(next HttpHandlerFunc) HttpHandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token := req.Header.Get(HEADER_NAME)
-		account, _ := a.manager.FromToken(token)
+		token := req.Header.Get(a.manager.config.HeaderName)
+		account, _ := a.manager.FromToken(req.Context(), token)
 		if account == nil {
-			WriteError(res, errors.New("You must login"), 401)
+			WriteError(res, ErrMustLogin)
 			return
 		}
+		auditEvent(req).Actor = account.Login
 		next(res, req)
 	}
 }
 
 func (a *AuthMiddleWare) MustBeRoot(next HttpHandlerFunc) HttpHandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token := req.Header.Get(HEADER_NAME)
-		account, _ := a.manager.FromToken(token)
-
-		if account == nil || !account.IsSupervisor() {
-			WriteError(res, errors.New("It can do only supervisor"), 401)
+		token := req.Header.Get(a.manager.config.HeaderName)
+		account, _ := a.manager.FromToken(req.Context(), token)
+		if account != nil {
+			auditEvent(req).Actor = account.Login
+		}
+		if account == nil {
+			WriteError(res, ErrMustLogin)
+			return
+		}
+		if !a.manager.config.IsSupervisor(account) {
+			WriteError(res, ErrOnlySupervisor)
 			return
 		}
 		next(res, req)
@@ -88,10 +179,54 @@ func (a *AuthMiddleWare) MustBeRoot(next HttpHandlerFunc) HttpHandlerFunc {
 
 func (a *AuthMiddleWare) MustChangeYourth(next HttpHandlerFuncWithAcc) HttpHandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token := req.Header.Get(HEADER_NAME)
-		account, _ := a.manager.FromToken(token)
+		token := req.Header.Get(a.manager.config.HeaderName)
+		account, _ := a.manager.FromToken(req.Context(), token)
 		if account == nil {
-			WriteError(res, errors.New("You must login"), 401)
+			WriteError(res, ErrMustLogin)
+			return
+		}
+		auditEvent(req).Actor = account.Login
+		next(res, req, account)
+	}
+}
+
+func (a *AuthMiddleWare) MustHaveSession(next HttpHandlerFuncWithSession) HttpHandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		token := req.Header.Get(a.manager.config.HeaderName)
+		account, session, _ := a.manager.SessionFromToken(req.Context(), token)
+		if account == nil {
+			WriteError(res, ErrMustLogin)
+			return
+		}
+		auditEvent(req).Actor = account.Login
+		next(res, req, account, session)
+	}
+}
+
+// RequirePermission passes only accounts which have permission through roles.
+func (a *AuthMiddleWare) RequirePermission(permission string, next HttpHandlerFunc) HttpHandlerFunc {
+	return a.RequirePermissionWithAcc(permission, func(res http.ResponseWriter, req *http.Request, account *Account) {
+		next(res, req)
+	})
+}
+
+// RequirePermissionWithAcc is RequirePermission for handlers which need account of caller.
+func (a *AuthMiddleWare) RequirePermissionWithAcc(permission string, next HttpHandlerFuncWithAcc) HttpHandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		token := req.Header.Get(a.manager.config.HeaderName)
+		account, _ := a.manager.FromToken(req.Context(), token)
+		if account == nil {
+			WriteError(res, ErrMustLogin)
+			return
+		}
+		auditEvent(req).Actor = account.Login
+		ok, err := a.manager.HasPermission(req.Context(), account, permission)
+		if err != nil {
+			WriteError(res, err)
+			return
+		}
+		if !ok {
+			WriteError(res, ErrNoPermission(permission))
 			return
 		}
 		next(res, req, account)
@@ -0,0 +1,29 @@
+package auth
+
+// commonPasswords are most common leaked passwords, they are rejected when policy
+// has BlockCommon. Keys are lowercase.
+var commonPasswords = map[string]bool{
+	"123456": true, "password": true, "12345678": true, "qwerty": true, "123456789": true,
+	"12345": true, "1234": true, "111111": true, "1234567": true, "dragon": true,
+	"123123": true, "baseball": true, "abc123": true, "football": true, "monkey": true,
+	"letmein": true, "696969": true, "shadow": true, "master": true, "666666": true,
+	"qwertyuiop": true, "123321": true, "mustang": true, "1234567890": true,
+	"michael": true, "654321": true, "superman": true, "1qaz2wsx": true, "7777777": true,
+	"121212": true, "000000": true, "qazwsx": true, "123qwe": true, "killer": true,
+	"trustno1": true, "jordan": true, "jennifer": true, "zxcvbnm": true, "asdfgh": true,
+	"hunter": true, "buster": true, "soccer": true, "harley": true, "batman": true,
+	"andrew": true, "tigger": true, "sunshine": true, "iloveyou": true, "2000": true,
+	"charlie": true, "robert": true, "thomas": true, "hockey": true, "ranger": true,
+	"daniel": true, "starwars": true, "klaster": true, "112233": true, "george": true,
+	"computer": true, "michelle": true, "jessica": true, "pepper": true, "1111": true,
+	"zxcvbn": true, "555555": true, "11111111": true, "131313": true, "freedom": true,
+	"777777": true, "pass": true, "maggie": true, "159753": true, "aaaaaa": true,
+	"ginger": true, "princess": true, "joshua": true, "cheese": true, "amanda": true,
+	"summer": true, "love": true, "ashley": true, "nicole": true, "chelsea": true,
+	"biteme": true, "matthew": true, "access": true, "yankees": true, "987654321": true,
+	"dallas": true, "austin": true, "thunder": true, "taylor": true, "matrix": true,
+	"admin": true, "welcome": true, "password1": true, "password123": true,
+	"qwerty123": true, "1q2w3e4r": true, "passw0rd": true, "p@ssw0rd": true,
+	"welcome1": true, "abc12345": true, "qwe123": true, "root": true, "toor": true,
+	"changeme": true, "secret": true,
+}
@@ -0,0 +1,354 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// MongoConfig: URI is full connection string (replica sets, authSource and other
+// options), without it URI is built from Host, Port, User, Password, AuthSource and
+// ReplicaSet. Name is database. Timeouts are in seconds. TLS is enabled by TLS, CA file
+// or certificate key file (PEM with client certificate and its key).
+type MongoConfig struct {
+	URI                    string `yaml:"uri" toml:"uri" env:"MONGO_URI"`
+	Host                   string `yaml:"host" toml:"host" env:"MONGO_HOST"`
+	Port                   int    `yaml:"port" toml:"port" env:"MONGO_PORT"`
+	User                   string `yaml:"user" toml:"user" env:"MONGO_USER"`
+	Password               string `yaml:"password" toml:"password" env:"MONGO_PWD"`
+	AuthSource             string `yaml:"auth_source" toml:"auth_source" env:"MONGO_AUTH_SOURCE"`
+	ReplicaSet             string `yaml:"replica_set" toml:"replica_set" env:"MONGO_REPLICA_SET"`
+	Name                   string `yaml:"name" toml:"name" env:"MONGO_DB"`
+	MinPoolSize            int    `yaml:"min_pool_size" toml:"min_pool_size" env:"MONGO_MIN_POOL_SIZE"`
+	MaxPoolSize            int    `yaml:"max_pool_size" toml:"max_pool_size" env:"MONGO_MAX_POOL_SIZE"`
+	ConnectTimeout         int    `yaml:"connect_timeout" toml:"connect_timeout" env:"MONGO_CONNECT_TIMEOUT"`
+	ServerSelectionTimeout int    `yaml:"server_selection_timeout" toml:"server_selection_timeout" env:"MONGO_SERVER_SELECTION_TIMEOUT"`
+	SocketTimeout          int    `yaml:"socket_timeout" toml:"socket_timeout" env:"MONGO_SOCKET_TIMEOUT"`
+	TLS                    bool   `yaml:"tls" toml:"tls" env:"MONGO_TLS"`
+	TLSCAFile              string `yaml:"tls_ca_file" toml:"tls_ca_file" env:"MONGO_TLS_CA_FILE"`
+	TLSCertificateKeyFile  string `yaml:"tls_certificate_key_file" toml:"tls_certificate_key_file" env:"MONGO_TLS_CERTIFICATE_KEY_FILE"`
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr" toml:"addr" env:"REDIS_ADDR"`
+	Password string `yaml:"password" toml:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db" toml:"db" env:"REDIS_DB"`
+}
+
+type SupervisorConfig struct {
+	Login    string `yaml:"login" toml:"login" env:"SUPERVISOR_LOGIN"`
+	Password string `yaml:"password" toml:"password" env:"SUPERVISOR_PASSWORD"`
+}
+
+// JWTConfig: Alg is HS256, RS256 or EdDSA, Keys is comma separated kid:secret (HS256)
+// or kid:path to PEM key (RS256, EdDSA), first is active. RotationPeriod in seconds
+// enables rotation of generated signing keys, 0 disables it.
+type JWTConfig struct {
+	Alg            string `yaml:"alg" toml:"alg" env:"JWT_ALG"`
+	Keys           string `yaml:"keys" toml:"keys" env:"JWT_KEYS"`
+	Issuer         string `yaml:"issuer" toml:"issuer" env:"JWT_ISSUER"`
+	RotationPeriod int    `yaml:"rotation_period" toml:"rotation_period" env:"JWT_ROTATION_PERIOD"`
+}
+
+// LockoutConfig: Threshold failed logins lock login for Duration seconds, every next
+// failure doubles it up to MaxDuration. Failures are forgotten after Reset seconds.
+// RateLimit is count of login requests from one address per RateWindow seconds, 0 disables it.
+type LockoutConfig struct {
+	Threshold   int `yaml:"threshold" toml:"threshold" env:"LOCKOUT_THRESHOLD"`
+	Duration    int `yaml:"duration" toml:"duration" env:"LOCKOUT_DURATION"`
+	MaxDuration int `yaml:"max_duration" toml:"max_duration" env:"LOCKOUT_MAX_DURATION"`
+	Reset       int `yaml:"reset" toml:"reset" env:"LOCKOUT_RESET"`
+	RateLimit   int `yaml:"rate_limit" toml:"rate_limit" env:"LOGIN_RATE_LIMIT"`
+	RateWindow  int `yaml:"rate_window" toml:"rate_window" env:"LOGIN_RATE_WINDOW"`
+}
+
+// MFAConfig: TTL is lifetime in seconds of challenge token between password and second
+// factor, Issuer is name of service shown by authenticator apps.
+type MFAConfig struct {
+	TTL    int    `yaml:"ttl" toml:"ttl" env:"MFA_TTL"`
+	Issuer string `yaml:"issuer" toml:"issuer" env:"TOTP_ISSUER"`
+}
+
+// LDAPConfig: URL enables LDAP provider, accounts are checked by bind with UserDN
+// template like uid=%s,ou=people,dc=example,dc=com
+type LDAPConfig struct {
+	URL          string `yaml:"url" toml:"url" env:"LDAP_URL"`
+	UserDN       string `yaml:"user_dn" toml:"user_dn" env:"LDAP_USER_DN"`
+	ProviderName string `yaml:"provider_name" toml:"provider_name" env:"LDAP_PROVIDER_NAME"`
+}
+
+// OIDCConfig: Issuer enables OpenID Connect provider, login starts at
+// /api/accounts/oidc/{ProviderName}/login and RedirectURL must point to
+// /api/accounts/oidc/{ProviderName}/callback
+type OIDCConfig struct {
+	Issuer       string `yaml:"issuer" toml:"issuer" env:"OIDC_ISSUER"`
+	ClientID     string `yaml:"client_id" toml:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret" env:"OIDC_CLIENT_SECRET"`
+	RedirectURL  string `yaml:"redirect_url" toml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+	ProviderName string `yaml:"provider_name" toml:"provider_name" env:"OIDC_PROVIDER_NAME"`
+	LoginClaim   string `yaml:"login_claim" toml:"login_claim" env:"OIDC_LOGIN_CLAIM"`
+}
+
+// Config is settings of service. Every field may be set in config file, by environment
+// variable of env tag and by flag named after file keys (mongo.port is -mongo-port).
+// TTLs and durations are in seconds.
+type Config struct {
+	Host           string `yaml:"host" toml:"host" env:"HOST"`
+	Port           int    `yaml:"port" toml:"port" env:"PORT"`
+	HeaderName     string `yaml:"header_name" toml:"header_name" env:"HEADER_NAME"`
+	SessionTTL     int    `yaml:"session_ttl" toml:"session_ttl" env:"SESSION_TTL"`
+	AccessTTL      int    `yaml:"access_ttl" toml:"access_ttl" env:"ACCESS_TTL"`
+	PasswordTTL    int    `yaml:"password_ttl" toml:"password_ttl" env:"PASSWORD_TTL"`
+	PasswordHasher string `yaml:"password_hasher" toml:"password_hasher" env:"PASSWORD_HASHER"`
+	// Storage is "mongo" or "memory", SessionStorage may move sessions to "redis".
+	Storage        string `yaml:"storage" toml:"storage" env:"STORAGE"`
+	SessionStorage string `yaml:"session_storage" toml:"session_storage" env:"SESSION_STORAGE"`
+	// StorageTimeout is deadline of every storage operation.
+	StorageTimeout int              `yaml:"storage_timeout" toml:"storage_timeout" env:"STORAGE_TIMEOUT"`
+	Mongo          MongoConfig      `yaml:"mongo" toml:"mongo"`
+	Redis          RedisConfig      `yaml:"redis" toml:"redis"`
+	Supervisor     SupervisorConfig `yaml:"supervisor" toml:"supervisor"`
+	JWT            JWTConfig        `yaml:"jwt" toml:"jwt"`
+	Lockout        LockoutConfig    `yaml:"lockout" toml:"lockout"`
+	MFA            MFAConfig        `yaml:"mfa" toml:"mfa"`
+	LDAP           LDAPConfig       `yaml:"ldap" toml:"ldap"`
+	OIDC           OIDCConfig       `yaml:"oidc" toml:"oidc"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Port:           8080,
+		HeaderName:     "Auth-Token",
+		SessionTTL:     2592000,
+		AccessTTL:      300,
+		PasswordTTL:    7776000,
+		PasswordHasher: "argon2id",
+		Storage:        "mongo",
+		StorageTimeout: 5,
+		Mongo: MongoConfig{Host: "localhost", Port: 27017, Name: "hot_wifi", MaxPoolSize: 100,
+			ConnectTimeout: 10, ServerSelectionTimeout: 5, SocketTimeout: 30},
+		JWT:     JWTConfig{Alg: "HS256"},
+		Lockout: LockoutConfig{Threshold: 5, Duration: 30, MaxDuration: 3600, Reset: 86400, RateLimit: 20, RateWindow: 60},
+		MFA:     MFAConfig{TTL: 300, Issuer: "hot_wifi"},
+		LDAP:    LDAPConfig{ProviderName: "ldap"},
+		OIDC:    OIDCConfig{ProviderName: "oidc", LoginClaim: "preferred_username"},
+	}
+}
+
+// configField is one setting of Config with names of its variable and flag.
+type configField struct {
+	env   string
+	flag  string
+	value reflect.Value
+}
+
+func configFields(v reflect.Value, prefix string) []configField {
+	result := []configField{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := prefix + strings.ReplaceAll(field.Tag.Get("yaml"), "_", "-")
+		if field.Type.Kind() == reflect.Struct {
+			result = append(result, configFields(v.Field(i), name+"-")...)
+			continue
+		}
+		result = append(result, configField{env: field.Tag.Get("env"), flag: name, value: v.Field(i)})
+	}
+	return result
+}
+
+func setConfigValue(value reflect.Value, s string) error {
+	switch value.Kind() {
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not integer", s)
+		}
+		value.SetInt(int64(i))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not boolean", s)
+		}
+		value.SetBool(b)
+	default:
+		value.SetString(s)
+	}
+	return nil
+}
+
+// readConfigFile reads YAML (.yaml, .yml) or TOML (.toml) file over config.
+func readConfigFile(config *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(config)
+	case ".toml":
+		var meta toml.MetaData
+		meta, err = toml.Decode(string(data), config)
+		if err == nil && len(meta.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown keys %v", meta.Undecoded())
+		}
+	default:
+		return fmt.Errorf("Config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("Error at read config file %s: %s", path, err)
+	}
+	return nil
+}
+
+// LoadConfig returns defaults overridden by config file (-config flag or CONFIG_FILE),
+// then by environment variables and then by flags of args. Error lists every
+// problem found.
+func LoadConfig(args []string) (*Config, error) {
+	config := DefaultConfig()
+	fields := configFields(reflect.ValueOf(config).Elem(), "")
+
+	flags := flag.NewFlagSet("hot_wifi", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file")
+	values := map[string]*string{}
+	for _, field := range fields {
+		values[field.flag] = flags.String(field.flag, "", "overrides "+field.env)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := readConfigFile(config, *path); err != nil {
+			return nil, err
+		}
+	}
+
+	problems := []error{}
+	set := func(field configField, source, s string) {
+		if err := setConfigValue(field.value, s); err != nil {
+			problems = append(problems, fmt.Errorf("Bad %s: %s", source, err))
+		}
+	}
+	for _, field := range fields {
+		if s := os.Getenv(field.env); s != "" {
+			set(field, field.env, s)
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		for _, field := range fields {
+			if field.flag == f.Name {
+				set(field, "-"+f.Name, *values[f.Name])
+			}
+		}
+	})
+
+	problems = append(problems, config.problems()...)
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return config, nil
+}
+
+// Validate returns all problems of config joined in one error.
+func (c *Config) Validate() error {
+	return errors.Join(c.problems()...)
+}
+
+func (c *Config) problems() []error {
+	result := []error{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			result = append(result, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Port > 0 && c.Port < 65536, "Bad port %d", c.Port)
+	check(c.HeaderName != "", "Header name must be set")
+	check(c.SessionTTL > 0, "Session TTL must be positive")
+	check(c.AccessTTL > 0, "Access TTL must be positive")
+	check(c.PasswordTTL > 0, "Password TTL must be positive")
+	_, err := NewPasswordHasher(c.PasswordHasher)
+	check(err == nil, "Unknown password hasher %s", c.PasswordHasher)
+
+	check(c.StorageTimeout > 0, "Storage timeout must be positive")
+	check(c.Storage == "mongo" || c.Storage == "memory", "Storage must be mongo or memory, not %q", c.Storage)
+	if c.Storage == "mongo" {
+		if c.Mongo.URI == "" {
+			check(c.Mongo.Host != "", "Mongo host must be set")
+			check(c.Mongo.Port > 0 && c.Mongo.Port < 65536, "Bad mongo port %d", c.Mongo.Port)
+		} else {
+			check(strings.HasPrefix(c.Mongo.URI, "mongodb://") || strings.HasPrefix(c.Mongo.URI, "mongodb+srv://"), "Mongo URI must start with mongodb:// or mongodb+srv://")
+		}
+		check(c.Mongo.Name != "", "Mongo database must be set")
+		check(c.Mongo.MinPoolSize >= 0 && c.Mongo.MaxPoolSize >= c.Mongo.MinPoolSize, "Mongo pool sizes must not be negative and max pool size must not be less than min")
+		check(c.Mongo.ConnectTimeout > 0 && c.Mongo.ServerSelectionTimeout > 0 && c.Mongo.SocketTimeout >= 0, "Mongo timeouts must be positive")
+		for _, file := range []string{c.Mongo.TLSCAFile, c.Mongo.TLSCertificateKeyFile} {
+			if file != "" {
+				_, err := os.Stat(file)
+				check(err == nil, "Mongo TLS file %s is not readable", file)
+			}
+		}
+	}
+	check(c.SessionStorage == "" || c.SessionStorage == "redis", "Session storage may be only redis, not %q", c.SessionStorage)
+	if c.SessionStorage == "redis" {
+		check(c.Redis.Addr != "", "Redis address must be set for redis session storage")
+	}
+
+	check(c.Supervisor.Login != "", "Supervisor login must be set")
+	check(c.Supervisor.Password != "", "Supervisor password must be set")
+
+	check(c.JWT.Alg == "HS256" || c.JWT.Alg == "RS256" || c.JWT.Alg == "EdDSA", "JWT algorithm must be HS256, RS256 or EdDSA, not %q", c.JWT.Alg)
+	check(c.JWT.RotationPeriod >= 0, "JWT rotation period must not be negative")
+
+	check(c.Lockout.Threshold > 0, "Lockout threshold must be positive")
+	check(c.Lockout.Duration >= 0 && c.Lockout.MaxDuration >= c.Lockout.Duration, "Lockout durations must not be negative and max duration must not be less than duration")
+	check(c.Lockout.Reset > 0, "Lockout reset must be positive")
+	check(c.Lockout.RateLimit >= 0, "Login rate limit must not be negative")
+	check(c.Lockout.RateWindow > 0, "Login rate window must be positive")
+
+	check(c.MFA.TTL > 0, "MFA TTL must be positive")
+	if c.LDAP.URL != "" {
+		check(strings.Count(c.LDAP.UserDN, "%s") == 1, "LDAP user DN must contain one %%s for login")
+		check(c.LDAP.ProviderName != "", "LDAP provider name must be set")
+	}
+	if c.OIDC.Issuer != "" {
+		check(c.OIDC.ClientID != "", "OIDC client id must be set")
+		check(c.OIDC.RedirectURL != "", "OIDC redirect url must be set")
+		check(c.OIDC.ProviderName != "", "OIDC provider name must be set")
+		check(c.OIDC.LoginClaim != "", "OIDC login claim must be set")
+	}
+	return result
+}
+
+// IsSupervisor reports that account is supervisor which has every permission.
+func (c *Config) IsSupervisor(acc *Account) bool {
+	return NormalizeLogin(acc.Login) == NormalizeLogin(c.Supervisor.Login)
+}
+
+// Hashing returns hashing of passwords by configured hasher.
+func (c *Config) Hashing() *PasswordHashing {
+	return mustPasswordHashing(c.PasswordHasher)
+}
+
+// TokenIssuer returns issuer of tokens signed by keys with configured lifetimes.
+func (c *Config) TokenIssuer(keys *KeyRing) *TokenIssuer {
+	return &TokenIssuer{
+		Keys:            keys,
+		TTL:             time.Duration(c.AccessTTL) * time.Second,
+		RefreshTTL:      time.Duration(c.SessionTTL) * time.Second,
+		MFATTL:          time.Duration(c.MFA.TTL) * time.Second,
+		Issuer:          c.JWT.Issuer,
+		SupervisorLogin: c.Supervisor.Login,
+	}
+}
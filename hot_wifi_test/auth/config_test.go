@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigSources(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+port: 9000
+session_ttl: 100
+supervisor:
+  login: admin
+  password: secret
+mongo:
+  host: mongo
+lockout:
+  threshold: 3
+`)
+	t.Setenv("SESSION_TTL", "200")
+	t.Setenv("MONGO_PORT", "27018")
+	config, err := LoadConfig([]string{"-config", path, "-mongo-port", "27019", "-lockout-rate-limit", "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != 9000 || config.Supervisor.Login != "admin" || config.Mongo.Host != "mongo" || config.Lockout.Threshold != 3 {
+		t.Errorf("file must override defaults, got %+v", config)
+	}
+	if config.SessionTTL != 200 {
+		t.Errorf("environment must override file, got %d", config.SessionTTL)
+	}
+	if config.Mongo.Port != 27019 || config.Lockout.RateLimit != 0 {
+		t.Errorf("flags must override environment, got %+v", config)
+	}
+	if config.AccessTTL != 300 || config.JWT.Alg != "HS256" {
+		t.Errorf("absent settings must have defaults, got %+v", config)
+	}
+}
+
+func TestLoadConfigToml(t *testing.T) {
+	path := writeConfigFile(t, "config.toml", `
+storage = "memory"
+
+[supervisor]
+login = "admin"
+password = "secret"
+`)
+	config, err := LoadConfig([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Storage != "memory" || config.Supervisor.Password != "secret" {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	unknown := writeConfigFile(t, "unknown.toml", "colour = \"red\"\n")
+	if _, err := LoadConfig([]string{"-config", unknown}); err == nil {
+		t.Errorf("unknown keys must be rejected")
+	}
+}
+
+func TestLoadConfigProblems(t *testing.T) {
+	t.Setenv("PORT", "eighty")
+	t.Setenv("STORAGE", "files")
+	t.Setenv("SUPERVISOR_LOGIN", "")
+	_, err := LoadConfig([]string{"-access-ttl", "0"})
+	if err == nil {
+		t.Fatal("bad config must be rejected")
+	}
+	for _, problem := range []string{"Bad PORT", "Access TTL", "Storage must be", "Supervisor login", "Supervisor password"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("problem %q must be reported, got %v", problem, err)
+		}
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	if err := newTestConfig().Validate(); err != nil {
+		t.Errorf("test config must be valid: %s", err)
+	}
+	if err := DefaultConfig().Validate(); err == nil || !strings.Contains(err.Error(), "Supervisor login") {
+		t.Errorf("supervisor must be configured explicitly, got %v", err)
+	}
+}
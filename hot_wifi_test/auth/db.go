@@ -2,15 +2,23 @@ package auth
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
-	"fmt"
 
-	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.mongodb.org/mongo-driver/x/bsonx"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func yieldIndex(key string, asc int, unique bool) mongo.IndexModel {
@@ -25,105 +33,275 @@ func yieldIndex(key string, asc int, unique bool) mongo.IndexModel {
 	return index
 }
 
-func yieldSessionIndexTtl(key string) mongo.IndexModel {
+func yieldIndexTtl(key string, ttl int) mongo.IndexModel {
 	index := mongo.IndexModel{}
 	index_options := &options.IndexOptions{}
 	index_options.SetBackground(true)
-	index_options.SetExpireAfterSeconds(int32(SESSION_TTL))
+	index_options.SetExpireAfterSeconds(int32(ttl))
 	keys := bsonx.Doc{{Key: key, Value: bsonx.Int32(-1)}}
 	index.Keys = keys
 	index.Options = index_options
 	return index
 }
 
-func InitDb() (*mongo.Database, error) {
-	uri := fmt.Sprintf("mongodb://%s:%s@%s:%v/%s", DB_USER, DB_PASS, DB_HOST, DB_PORT, DB_NAME)
+// Mongo is one client shared by all mongo storages.
+type Mongo struct {
+	Client *mongo.Client
+	DB     *mongo.Database
+}
+
+// mongoURI returns connection string of config and its copy without password for logs.
+func mongoURI(config *MongoConfig) (string, string) {
+	uri := config.URI
+	if uri == "" {
+		u := url.URL{Scheme: "mongodb", Host: net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), Path: "/" + config.Name}
+		if config.User != "" {
+			u.User = url.UserPassword(config.User, config.Password)
+		}
+		query := url.Values{}
+		if config.AuthSource != "" {
+			query.Set("authSource", config.AuthSource)
+		}
+		if config.ReplicaSet != "" {
+			query.Set("replicaSet", config.ReplicaSet)
+		}
+		u.RawQuery = query.Encode()
+		uri = u.String()
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri, "mongo uri which can not be parsed"
+	}
+	return uri, u.Redacted()
+}
+
+func mongoTLSConfig(config *MongoConfig) (*tls.Config, error) {
+	if !config.TLS && config.TLSCAFile == "" && config.TLSCertificateKeyFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.TLSCAFile != "" {
+		data, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates in mongo CA file %s", config.TLSCAFile)
+		}
+	}
+	if config.TLSCertificateKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCertificateKeyFile, config.TLSCertificateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func mongoClientOptions(config *MongoConfig) (*options.ClientOptions, error) {
+	uri, _ := mongoURI(config)
+	opts := options.Client().ApplyURI(uri).
+		SetMinPoolSize(uint64(config.MinPoolSize)).
+		SetMaxPoolSize(uint64(config.MaxPoolSize)).
+		SetConnectTimeout(time.Duration(config.ConnectTimeout) * time.Second).
+		SetServerSelectionTimeout(time.Duration(config.ServerSelectionTimeout) * time.Second).
+		SetSocketTimeout(time.Duration(config.SocketTimeout) * time.Second)
+	tlsConfig, err := mongoTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return opts, opts.Validate()
+}
 
-	log.Printf("Connect to %s", uri)
-	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+// ConnectMongo connects to mongo and checks that it answers.
+func ConnectMongo(config *MongoConfig) (*Mongo, error) {
+	_, redacted := mongoURI(config)
+	log.Printf("Connect to %s", redacted)
+	opts, err := mongoClientOptions(config)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ConnectTimeout)*time.Second)
 	defer cancel()
-	err = client.Connect(ctx)
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
-	db := client.Database(DB_NAME)
-	return db, nil
+	m := &Mongo{Client: client, DB: client.Database(config.Name)}
+	err = m.Ping(ctx)
+	if err != nil {
+		m.Close(context.Background())
+		return nil, err
+	}
+	return m, nil
+}
+
+// Ping checks that primary answers.
+func (m *Mongo) Ping(ctx context.Context) error {
+	return m.Client.Ping(ctx, readpref.Primary())
+}
+
+// Close waits for operations in progress and disconnects.
+func (m *Mongo) Close(ctx context.Context) error {
+	return m.Client.Disconnect(ctx)
 }
 
-type AccountsStorage struct {
+type MongoAccountsStorage struct {
 	Accounts *mongo.Collection
 }
 
-type PolicyStorage struct {
+type MongoPolicyStorage struct {
 	Policy *mongo.Collection
 }
 
-type SessionsStorage struct {
+// MongoSessionsStorage keeps sessions with expiry time which is removed by ttl index.
+// Expiry is sliding: touching session prolongs it for ttl.
+type MongoSessionsStorage struct {
 	Sessions *mongo.Collection
+	ttl      time.Duration
 }
 
-func NewAccountsStorage() (*AccountsStorage, error) {
-	db, err := InitDb()
-	if err != nil {
-		return nil, err
-	}
+// mongoSession is stored session with its expiry time.
+type mongoSession struct {
+	Session `bson:",inline"`
+	Expires time.Time `bson:"expires"`
+}
+
+type MongoRefreshTokensStorage struct {
+	RefreshTokens *mongo.Collection
+}
+
+type MongoRolesStorage struct {
+	Roles *mongo.Collection
+}
 
+type MongoLockoutsStorage struct {
+	Lockouts *mongo.Collection
+}
+
+type MongoAuditStorage struct {
+	Events *mongo.Collection
+}
+
+var _ AccountsStorage = (*MongoAccountsStorage)(nil)
+var _ PolicyStorage = (*MongoPolicyStorage)(nil)
+var _ SessionsStorage = (*MongoSessionsStorage)(nil)
+var _ RefreshTokensStorage = (*MongoRefreshTokensStorage)(nil)
+var _ RolesStorage = (*MongoRolesStorage)(nil)
+var _ LockoutsStorage = (*MongoLockoutsStorage)(nil)
+var _ AuditStorage = (*MongoAuditStorage)(nil)
+
+func NewMongoAccountsStorage(db *mongo.Database) (*MongoAccountsStorage, error) {
 	accountsCollection := db.Collection("accounts")
 	accountsCollection.Indexes().CreateMany(
-		context.TODO(),
+		context.Background(),
 		[]mongo.IndexModel{
 			yieldIndex("login", 1, true),
 			yieldIndex("isExternalAccount", 1, false),
+			{Keys: bson.D{{Key: "idempotency_key", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
 		})
 
-	result := AccountsStorage{Accounts: accountsCollection}
+	result := MongoAccountsStorage{Accounts: accountsCollection}
 	return &result, nil
 }
 
-func NewPolicyStorage() (*PolicyStorage, error) {
-	db, err := InitDb()
-	if err != nil {
-		return nil, err
-	}
+func NewMongoPolicyStorage(db *mongo.Database) (*MongoPolicyStorage, error) {
 	policyCollection := db.Collection("policy")
-	result := PolicyStorage{Policy: policyCollection}
+	result := MongoPolicyStorage{Policy: policyCollection}
 	return &result, nil
 }
 
-func NewSessionStorage() (*SessionsStorage, error) {
-	db, err := InitDb()
-	if err != nil {
-		return nil, err
-	}
+func NewMongoSessionStorage(db *mongo.Database, ttl time.Duration) (*MongoSessionsStorage, error) {
 	sessionsCollection := db.Collection("sessions")
 	sessionsCollection.Indexes().CreateMany(
-		context.TODO(),
+		context.Background(),
 		[]mongo.IndexModel{
-			yieldIndex("login", -1, true),
-			yieldSessionIndexTtl("token"),
+			yieldIndex("login", -1, false),
+			yieldIndex("sid", 1, true),
+			yieldIndexTtl("expires", 0),
 		})
 
-	result := SessionsStorage{Sessions: sessionsCollection}
+	result := MongoSessionsStorage{Sessions: sessionsCollection, ttl: ttl}
 	return &result, nil
 }
 
-func (st *SessionsStorage) SetSession(session *Session) error {
-	uOpts := options.UpdateOptions{}
-	uOpts.SetUpsert(true)
-	_, err := st.Sessions.UpdateOne(context.TODO(), bson.M{"login": session.Login}, bson.M{"$set": session}, &uOpts)
+func NewMongoRefreshTokensStorage(db *mongo.Database) (*MongoRefreshTokensStorage, error) {
+	tokensCollection := db.Collection("refresh_tokens")
+	tokensCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			yieldIndex("hash", 1, true),
+			yieldIndex("family", 1, false),
+			yieldIndexTtl("expires", 0),
+		})
+
+	result := MongoRefreshTokensStorage{RefreshTokens: tokensCollection}
+	return &result, nil
+}
+
+func NewMongoRolesStorage(db *mongo.Database) (*MongoRolesStorage, error) {
+	rolesCollection := db.Collection("roles")
+	rolesCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			yieldIndex("name", 1, true),
+		})
+
+	result := MongoRolesStorage{Roles: rolesCollection}
+	return &result, nil
+}
+
+func NewMongoLockoutsStorage(db *mongo.Database, reset time.Duration) (*MongoLockoutsStorage, error) {
+	lockoutsCollection := db.Collection("lockouts")
+	lockoutsCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			yieldIndex("login", 1, true),
+			yieldIndexTtl("last_failure", int(reset.Seconds())),
+		})
+
+	result := MongoLockoutsStorage{Lockouts: lockoutsCollection}
+	return &result, nil
+}
+
+func NewMongoAuditStorage(db *mongo.Database) (*MongoAuditStorage, error) {
+	eventsCollection := db.Collection("audit")
+	eventsCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			yieldIndex("time", -1, false),
+			yieldIndex("actor", 1, false),
+			yieldIndex("action", 1, false),
+		})
+
+	result := MongoAuditStorage{Events: eventsCollection}
+	return &result, nil
+}
+
+func (st *MongoAccountsStorage) Ping(ctx context.Context) error {
+	return st.Accounts.Database().Client().Ping(ctx, readpref.Primary())
+}
+
+func (st *MongoSessionsStorage) Ping(ctx context.Context) error {
+	return st.Sessions.Database().Client().Ping(ctx, readpref.Primary())
+}
+
+func (st *MongoSessionsStorage) SetSession(ctx context.Context, session *Session) error {
+	_, err := st.Sessions.InsertOne(ctx, &mongoSession{Session: *session, Expires: session.LastSeen.Add(st.ttl)})
 	if err != nil {
-		log.Printf("Error at set account : %s", err)
+		log.Printf("Error at set session : %s", err)
 		return err
 	}
 	return nil
 }
 
-func (st *SessionsStorage) GetSession(token string) (*Session, error) {
-	res := st.Sessions.FindOne(context.TODO(), bson.M{"token": token})
+func (st *MongoSessionsStorage) GetSession(ctx context.Context, id string) (*Session, error) {
+	res := st.Sessions.FindOne(ctx, bson.M{"sid": id})
 	s := Session{}
 	err := res.Decode(&s)
 	if err == mongo.ErrNoDocuments {
@@ -136,8 +314,50 @@ func (st *SessionsStorage) GetSession(token string) (*Session, error) {
 	return &s, nil
 }
 
-func (st *SessionsStorage) DeleteSession(login string) error {
-	_, err := st.Sessions.DeleteOne(context.TODO(), bson.M{"login": login})
+func (st *MongoSessionsStorage) GetSessions(ctx context.Context, login string) ([]Session, error) {
+	findOpts := options.Find().SetSort(bson.M{"created": 1})
+	cursor, err := st.Sessions.Find(ctx, bson.M{"login": login}, findOpts)
+	if err != nil {
+		log.Printf("Error at get sessions : %s", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	result := []Session{}
+	for cursor.Next(ctx) {
+		var s Session
+		err := cursor.Decode(&s)
+		if err != nil {
+			log.Printf("Error at decoding session %s", err)
+			continue
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// CountSessions counts stored sessions, expired ones are removed by ttl index.
+func (st *MongoSessionsStorage) CountSessions(ctx context.Context) (int, error) {
+	count, err := st.Sessions.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		log.Printf("Error at count sessions : %s", err)
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// TouchSession updates last seen time and prolongs session for ttl.
+func (st *MongoSessionsStorage) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+	update := bson.M{"$set": bson.M{"last_seen": lastSeen, "expires": lastSeen.Add(st.ttl)}}
+	_, err := st.Sessions.UpdateOne(ctx, bson.M{"sid": id}, update)
+	if err != nil {
+		log.Printf("Error at touch session %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *MongoSessionsStorage) DeleteSession(ctx context.Context, id string) error {
+	_, err := st.Sessions.DeleteOne(ctx, bson.M{"sid": id})
 	if err != nil {
 		log.Printf("Error at deleting session %s", err)
 		return err
@@ -145,33 +365,114 @@ func (st *SessionsStorage) DeleteSession(login string) error {
 	return nil
 }
 
-func (st *SessionsStorage) GetSessionByLogin(login string) (*Session, error) {
-	res := st.Sessions.FindOne(context.TODO(), bson.M{"login": login})
-	s := Session{}
-	err := res.Decode(&s)
+func (st *MongoSessionsStorage) DeleteSessions(ctx context.Context, login string) error {
+	_, err := st.Sessions.DeleteMany(ctx, bson.M{"login": login})
+	if err != nil {
+		log.Printf("Error at deleting sessions %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *MongoRefreshTokensStorage) SetRefreshToken(ctx context.Context, token *RefreshToken) error {
+	_, err := st.RefreshTokens.InsertOne(ctx, token)
+	if err != nil {
+		log.Printf("Error at set refresh token : %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *MongoRefreshTokensStorage) GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	res := st.RefreshTokens.FindOne(ctx, bson.M{"hash": hash})
+	t := RefreshToken{}
+	err := res.Decode(&t)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
 	if err != nil {
-		log.Printf("Error at decode session: %s", err)
+		log.Printf("Error at decode refresh token: %s", err)
 		return nil, err
 	}
-	return &s, nil
+	return &t, nil
 }
 
-func (st *AccountsStorage) SetAccount(account *Account) (interface{}, error) {
-	uOpts := options.UpdateOptions{}
-	uOpts.SetUpsert(true)
-	result, err := st.Accounts.UpdateOne(context.TODO(), bson.M{"login": account.Login}, bson.M{"$set": account}, &uOpts)
+func (st *MongoRefreshTokensStorage) UseRefreshToken(ctx context.Context, hash string) (bool, error) {
+	result, err := st.RefreshTokens.UpdateOne(ctx, bson.M{"hash": hash, "used": false}, bson.M{"$set": bson.M{"used": true}})
 	if err != nil {
-		log.Printf("Error at set account : %s", err)
-		return nil, err
+		log.Printf("Error at use refresh token: %s", err)
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (st *MongoRefreshTokensStorage) DeleteRefreshTokens(ctx context.Context, family string) error {
+	_, err := st.RefreshTokens.DeleteMany(ctx, bson.M{"family": family})
+	if err != nil {
+		log.Printf("Error at delete refresh tokens: %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *MongoAccountsStorage) CreateAccount(ctx context.Context, account *Account) error {
+	id := primitive.NewObjectID()
+	stored := *account
+	stored.ID = &id
+	_, err := st.Accounts.InsertOne(ctx, &stored)
+	if mongo.IsDuplicateKeyError(err) {
+		if strings.Contains(err.Error(), "idempotency_key") {
+			return ErrIdempotencyKeyReused
+		}
+		return ErrLoginAlreadyExists
+	}
+	if err != nil {
+		log.Printf("Error at create account : %s", err)
+		return err
+	}
+	account.ID = &id
+	return nil
+}
+
+func (st *MongoAccountsStorage) UpdateAccount(ctx context.Context, account *Account) error {
+	if account.ID == nil {
+		return ErrAccountNotFound
+	}
+	updated := *account
+	updated.Version++
+	filter := bson.M{"_id": account.ID, "version": versionFilter(account.Version)}
+	result, err := st.Accounts.ReplaceOne(ctx, filter, &updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrLoginAlreadyExists
+	}
+	if err != nil {
+		log.Printf("Error at update account : %s", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := st.Accounts.CountDocuments(ctx, bson.M{"_id": account.ID})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrAccountNotFound
+		}
+		return ErrVersionConflict
+	}
+	account.Version = updated.Version
+	return nil
+}
+
+// versionFilter matches version of document, documents stored before versions had version 0.
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
 	}
-	return result.UpsertedID, nil
+	return version
 }
 
-func (st *AccountsStorage) GetAccount(login string) (*Account, error) {
-	result := st.Accounts.FindOne(context.TODO(), bson.M{"login": login})
+func (st *MongoAccountsStorage) GetAccount(ctx context.Context, login string) (*Account, error) {
+	result := st.Accounts.FindOne(ctx, bson.M{"login": login})
 	var acc Account
 	err := result.Decode(&acc)
 	if err == mongo.ErrNoDocuments {
@@ -184,15 +485,18 @@ func (st *AccountsStorage) GetAccount(login string) (*Account, error) {
 	return &acc, err
 }
 
-func (st *AccountsStorage) GetAccountById(id string) (*Account, error) {
+func (st *MongoAccountsStorage) GetAccountById(ctx context.Context, id string) (*Account, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Printf("Can not get object id from %s : %s", id, err)
 		return nil, err
 	}
-	result := st.Accounts.FindOne(context.TODO(), bson.D{{"_id", objectID}})
+	result := st.Accounts.FindOne(ctx, bson.M{"_id": objectID})
 	var acc Account
 	err = result.Decode(&acc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrAccountNotFound
+	}
 	if err != nil {
 		log.Printf("Error at get account : %s", err)
 		return nil, err
@@ -200,14 +504,14 @@ func (st *AccountsStorage) GetAccountById(id string) (*Account, error) {
 	return &acc, err
 }
 
-func (st *AccountsStorage) GetAccountsViews() ([]AccountView, error) {
-	cursor, err := st.Accounts.Find(context.TODO(), bson.M{})
+func (st *MongoAccountsStorage) GetAccountsViews(ctx context.Context) ([]AccountView, error) {
+	cursor, err := st.Accounts.Find(ctx, bson.M{})
 	if err != nil {
 		log.Printf("Error at get accounts : %s", err)
 		return nil, err
 	}
 	result := []AccountView{}
-	for ; cursor.Next(context.TODO()); {
+	for cursor.Next(ctx) {
 		var acc AccountView
 		err := cursor.Decode(&acc)
 		if err != nil {
@@ -219,13 +523,13 @@ func (st *AccountsStorage) GetAccountsViews() ([]AccountView, error) {
 	return result, nil
 }
 
-func (at *AccountsStorage) DeleteAccount(id string) error {
+func (at *MongoAccountsStorage) DeleteAccount(ctx context.Context, id string) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		log.Printf("Can not get object id from %s : %s", id, err)
 		return err
 	}
-	_, err = at.Accounts.DeleteOne(context.TODO(), bson.D{{"_id", objectID}})
+	_, err = at.Accounts.DeleteOne(ctx, bson.M{"_id": objectID})
 	if err != nil {
 		log.Printf("Error at delete account : %s", err)
 		return err
@@ -233,23 +537,39 @@ func (at *AccountsStorage) DeleteAccount(id string) error {
 	return nil
 }
 
-func (st *PolicyStorage) SetPolicy(p *PasswordPolicy) (error) {
-	upsert := true
-	upsertOpts := options.UpdateOptions{Upsert: &upsert}
-	_, err := st.Policy.UpdateOne(context.TODO(), bson.M{}, bson.M{"$set": p}, &upsertOpts)
+func (st *MongoPolicyStorage) SetPolicy(ctx context.Context, p *PasswordPolicy) error {
+	updated := *p
+	updated.Version++
+	result, err := st.Policy.ReplaceOne(ctx, bson.M{"version": versionFilter(p.Version)}, &updated)
 	if err != nil {
 		log.Printf("Error at update policy: %s", err)
 		return err
 	}
+	if result.MatchedCount == 0 {
+		if p.Version != 0 {
+			return ErrVersionConflict
+		}
+		// policy was never stored, insert it unless other request did it already
+		inserted, err := st.Policy.UpdateOne(ctx, bson.M{}, bson.M{"$setOnInsert": &updated}, options.Update().SetUpsert(true))
+		if err != nil {
+			log.Printf("Error at insert policy: %s", err)
+			return err
+		}
+		if inserted.UpsertedCount == 0 {
+			return ErrVersionConflict
+		}
+	}
+	p.Version = updated.Version
 	return nil
 }
 
-func (st *PolicyStorage) GetPolicy() (*PasswordPolicy, error) {
-	result := st.Policy.FindOne(context.TODO(), bson.M{})
+func (st *MongoPolicyStorage) GetPolicy(ctx context.Context) (*PasswordPolicy, error) {
+	result := st.Policy.FindOne(ctx, bson.M{})
 	var policy PasswordPolicy
 	err := result.Decode(&policy)
 	if err == mongo.ErrNoDocuments {
-		return DEFAULT_POLICY, nil
+		policy = *DEFAULT_POLICY
+		return &policy, nil
 	}
 	if err != nil {
 		log.Printf("Error at read policy: %s", err)
@@ -257,3 +577,177 @@ func (st *PolicyStorage) GetPolicy() (*PasswordPolicy, error) {
 	}
 	return &policy, err
 }
+
+func (st *MongoRolesStorage) SetRole(ctx context.Context, role *Role) error {
+	uOpts := options.UpdateOptions{}
+	uOpts.SetUpsert(true)
+	_, err := st.Roles.UpdateOne(ctx, bson.M{"name": role.Name}, bson.M{"$set": role}, &uOpts)
+	if err != nil {
+		log.Printf("Error at set role : %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *MongoRolesStorage) GetRole(ctx context.Context, name string) (*Role, error) {
+	result := st.Roles.FindOne(ctx, bson.M{"name": name})
+	var role Role
+	err := result.Decode(&role)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		log.Printf("Error at get role : %s", err)
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (st *MongoRolesStorage) GetRoles(ctx context.Context) ([]Role, error) {
+	findOpts := options.Find().SetSort(bson.M{"name": 1})
+	cursor, err := st.Roles.Find(ctx, bson.M{}, findOpts)
+	if err != nil {
+		log.Printf("Error at get roles : %s", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	result := []Role{}
+	for cursor.Next(ctx) {
+		var role Role
+		err := cursor.Decode(&role)
+		if err != nil {
+			log.Printf("Error at decoding role %s", err)
+			continue
+		}
+		result = append(result, role)
+	}
+	return result, nil
+}
+
+func (st *MongoRolesStorage) DeleteRole(ctx context.Context, name string) error {
+	_, err := st.Roles.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		log.Printf("Error at delete role : %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *MongoLockoutsStorage) AddFailure(ctx context.Context, login string, at time.Time) (*Lockout, error) {
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	result := st.Lockouts.FindOneAndUpdate(
+		ctx,
+		bson.M{"login": login},
+		bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"last_failure": at}},
+		opts)
+	var lockout Lockout
+	err := result.Decode(&lockout)
+	if err != nil {
+		log.Printf("Error at add login failure : %s", err)
+		return nil, err
+	}
+	return &lockout, nil
+}
+
+func (st *MongoLockoutsStorage) SetLockedUntil(ctx context.Context, login string, until time.Time) error {
+	_, err := st.Lockouts.UpdateOne(ctx, bson.M{"login": login}, bson.M{"$set": bson.M{"locked_until": until}})
+	if err != nil {
+		log.Printf("Error at lock login : %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *MongoLockoutsStorage) GetLockout(ctx context.Context, login string) (*Lockout, error) {
+	result := st.Lockouts.FindOne(ctx, bson.M{"login": login})
+	var lockout Lockout
+	err := result.Decode(&lockout)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error at get lockout : %s", err)
+		return nil, err
+	}
+	return &lockout, nil
+}
+
+func (st *MongoLockoutsStorage) GetLockouts(ctx context.Context) ([]Lockout, error) {
+	findOpts := options.Find().SetSort(bson.M{"login": 1})
+	cursor, err := st.Lockouts.Find(ctx, bson.M{}, findOpts)
+	if err != nil {
+		log.Printf("Error at get lockouts : %s", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	result := []Lockout{}
+	for cursor.Next(ctx) {
+		var lockout Lockout
+		err := cursor.Decode(&lockout)
+		if err != nil {
+			log.Printf("Error at decoding lockout %s", err)
+			continue
+		}
+		result = append(result, lockout)
+	}
+	return result, nil
+}
+
+func (st *MongoLockoutsStorage) DeleteLockout(ctx context.Context, login string) error {
+	_, err := st.Lockouts.DeleteOne(ctx, bson.M{"login": login})
+	if err != nil {
+		log.Printf("Error at delete lockout : %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *MongoAuditStorage) AddEvent(ctx context.Context, event *AuditEvent) error {
+	_, err := st.Events.InsertOne(ctx, event)
+	if err != nil {
+		log.Printf("Error at add audit event : %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *MongoAuditStorage) FindEvents(ctx context.Context, filter *AuditFilter) ([]AuditEvent, error) {
+	query := bson.M{}
+	if filter.Actor != "" {
+		query["actor"] = filter.Actor
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	period := bson.M{}
+	if !filter.From.IsZero() {
+		period["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		period["$lt"] = filter.To
+	}
+	if len(period) > 0 {
+		query["time"] = period
+	}
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(filter.Offset)).
+		SetLimit(int64(filter.Limit))
+	cursor, err := st.Events.Find(ctx, query, findOpts)
+	if err != nil {
+		log.Printf("Error at find audit events : %s", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	result := []AuditEvent{}
+	for cursor.Next(ctx) {
+		var event AuditEvent
+		err := cursor.Decode(&event)
+		if err != nil {
+			log.Printf("Error at decoding audit event %s", err)
+			continue
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxBodySize       = 64 << 10
+	maxLoginLength    = 128
+	maxPasswordLength = 1024
+)
+
+var ErrUnsupportedMediaType = NewError(KindUnsupportedMediaType, "unsupported_media_type", "Request body must be application/json")
+var ErrBodyTooLarge = NewError(KindTooLarge, "body_too_large", "Request body is too large")
+var ErrInvalidFields = NewError(KindValidation, "invalid_fields", "Request has invalid fields")
+
+// FieldError is problem of one field of request, field is its JSON name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// fieldsValidator is request data which checks its fields after decoding.
+type fieldsValidator interface {
+	validateFields() []FieldError
+}
+
+// fieldErrors collects problems of fields, check adds problem if condition is false.
+type fieldErrors []FieldError
+
+func (fe *fieldErrors) check(ok bool, field, message string) {
+	if !ok {
+		*fe = append(*fe, FieldError{Field: field, Message: message})
+	}
+}
+
+// login checks login of new or renamed account by rules of NormalizeLogin.
+func (fe *fieldErrors) login(field, login string) {
+	login = NormalizeLogin(login)
+	fe.check(login != "", field, "must not be empty")
+	fe.check(len(login) <= maxLoginLength, field, "must be not longer than 128 bytes")
+	fe.check(login == "" || loginRe.MatchString(login), field, "must have only latin letters, digits and . _ @ - and start with letter or digit")
+}
+
+func (fe *fieldErrors) password(field, password string) {
+	fe.check(password != "", field, "must not be empty")
+	fe.check(len(password) <= maxPasswordLength, field, "must be not longer than 1024 bytes")
+}
+
+// decodeJSON reads body of request into v. Body must be application/json not larger than
+// maxBodySize with one value which has only known fields. If v is fieldsValidator its
+// problems are returned with ErrInvalidFields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return ErrUnsupportedMediaType
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err == nil && decoder.Decode(&json.RawMessage{}) != io.EOF {
+		err = errors.New("data after JSON value")
+	}
+	if err != nil {
+		return decodeError(err)
+	}
+	if validator, ok := v.(fieldsValidator); ok {
+		if fields := validator.validateFields(); len(fields) > 0 {
+			return ErrInvalidFields.WithFields(fields)
+		}
+	}
+	return nil
+}
+
+// decodeError tells which field is unknown or has wrong type if decoder knows it.
+func decodeError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrBodyTooLarge
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return ErrInvalidFields.WithFields([]FieldError{{Field: typeErr.Field, Message: "must be " + typeErr.Type.String()}})
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return ErrInvalidFields.WithFields([]FieldError{{Field: strings.Trim(field, `"`), Message: "is unknown"}})
+	}
+	return ErrMalformedBody.Wrap(err)
+}
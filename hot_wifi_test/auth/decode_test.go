@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postRaw(url, contentType, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	return execResp(req)
+}
+
+func TestDecodeBodyOfRealRequest(t *testing.T) {
+	prepareAccount("remote", "remotePASS1")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/accounts/login", "application/json; charset=utf-8",
+		strings.NewReader(`{"login":"remote","password":"remotePASS1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !bytes.Contains(body, []byte(`"auth-token"`)) {
+		t.Errorf("login over network must succeed, got %v %s", resp.StatusCode, body)
+	}
+}
+
+func TestDecodeRejectsBadBodies(t *testing.T) {
+	login := "/api/accounts/login"
+	if rr := postRaw(login, "text/plain", `{"login":"user","password":"userPASS1"}`); rr.Code != 415 ||
+		rr.Body.String() != problemBody(415, "unsupported_media_type", "Request body must be application/json") {
+		t.Errorf("body must be JSON, got %v %v", rr.Code, rr.Body.String())
+	}
+	large := `{"login":"user","password":"` + strings.Repeat("a", maxBodySize) + `"}`
+	if rr := postRaw(login, "application/json", large); rr.Code != 413 {
+		t.Errorf("large body must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+	if rr := postRaw(login, "application/json", `{"login":"user","password":"userPASS1"} {}`); rr.Code != 400 ||
+		rr.Body.String() != problemBody(400, "malformed_body", "Request body is not valid JSON") {
+		t.Errorf("data after value must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+	if rr := postRaw(login, "application/json", ``); rr.Code != 400 {
+		t.Errorf("empty body must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+
+	var problem Problem
+	rr := postRaw(login, "application/json", `{"login":"user","password":"userPASS1","admin":true}`)
+	json.Unmarshal(rr.Body.Bytes(), &problem)
+	if rr.Code != 400 || problem.Code != "invalid_fields" || len(problem.Errors) != 1 || problem.Errors[0] != (FieldError{Field: "admin", Message: "is unknown"}) {
+		t.Errorf("unknown field must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+	problem = Problem{}
+	rr = postRaw(login, "application/json", `{"login":1,"password":"userPASS1"}`)
+	json.Unmarshal(rr.Body.Bytes(), &problem)
+	if rr.Code != 400 || len(problem.Errors) != 1 || problem.Errors[0] != (FieldError{Field: "login", Message: "must be string"}) {
+		t.Errorf("field of wrong type must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+}
+
+func fieldsOf(v fieldsValidator) map[string]bool {
+	result := map[string]bool{}
+	for _, fe := range v.validateFields() {
+		result[fe.Field] = true
+	}
+	return result
+}
+
+func TestValidateFields(t *testing.T) {
+	if fields := fieldsOf(&LoginData{}); !fields["login"] || !fields["password"] {
+		t.Errorf("empty login data must be invalid, got %v", fields)
+	}
+	if fields := fieldsOf(&LoginData{Login: "old login", Password: "pass"}); len(fields) != 0 {
+		t.Errorf("login of existing accounts must not be checked by characters, got %v", fields)
+	}
+	if fields := fieldsOf(&ChangePasswordData{Old: "old", New: strings.Repeat("a", maxPasswordLength+1)}); len(fields) != 1 || !fields["newPassword"] {
+		t.Errorf("too long password must be invalid, got %v", fields)
+	}
+	if fields := fieldsOf(&AccountCreateData{Login: "new\tuser", Password: "userPASS1"}); len(fields) != 1 || !fields["login"] {
+		t.Errorf("login with control characters must be invalid, got %v", fields)
+	}
+	if fields := fieldsOf(&AccountCreateData{Login: "external", Password: "userPASS1", IsExternalAccount: true}); len(fields) != 1 || !fields["password"] {
+		t.Errorf("external account must not have password, got %v", fields)
+	}
+	if fields := fieldsOf(DEFAULT_POLICY); len(fields) != 0 {
+		t.Errorf("default policy must be valid, got %v", fields)
+	}
+	policy := PasswordPolicy{Length: 10, MaxLength: 5, MinNumbers: -1, HistoryDepth: 50, MinAge: 10, MaxAge: 5, Require2FA: "some"}
+	fields := fieldsOf(&policy)
+	for _, field := range []string{"max_length", "min_numbers", "history_depth", "min_age", "require_2fa"} {
+		if !fields[field] {
+			t.Errorf("field %s must be invalid, got %v", field, fields)
+		}
+	}
+}
+
+func TestHandlersValidateFields(t *testing.T) {
+	body := requestWithToken("POST", "/api/accounts/password/policy", sToken, PasswordPolicy{Length: -1})
+	var problem Problem
+	json.Unmarshal([]byte(body), &problem)
+	if problem.Code != "invalid_fields" || len(problem.Errors) != 1 || problem.Errors[0].Field != "length" {
+		t.Errorf("invalid policy must be rejected with details, got %v", body)
+	}
+
+	data, _ := json.Marshal(&AccountCreateData{Login: "", Password: "userPASS1"})
+	req, _ := http.NewRequest("POST", "/accounts", bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, sToken)
+	rr := execResp(req)
+	problem = Problem{}
+	json.Unmarshal(rr.Body.Bytes(), &problem)
+	if rr.Code != 400 || len(problem.Errors) != 1 || problem.Errors[0] != (FieldError{Field: "login", Message: "must not be empty"}) {
+		t.Errorf("account without login must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+}
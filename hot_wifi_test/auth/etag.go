@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Accounts and policy carry version which storages check and increment at every update.
+// Version is sent in ETag header of reads and updates must send it back in If-Match.
+
+var ErrIfMatchRequired = NewError(KindPreconditionRequired, "if_match_required", "Send ETag of record in If-Match header")
+
+func etag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// ifMatch checks that If-Match header of request has ETag of version. It answers with
+// error itself and returns false if header is absent or has other ETags.
+func ifMatch(w http.ResponseWriter, r *http.Request, version int64) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		WriteError(w, ErrIfMatchRequired)
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	WriteError(w, ErrVersionConflict)
+	return false
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func requestIfMatch(method, url, ifMatch string, data interface{}) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	if data != nil {
+		json.NewEncoder(&body).Encode(data)
+	}
+	req, _ := http.NewRequest(method, url, &body)
+	req.Header.Set(cfg.HeaderName, sToken)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	return execResp(req)
+}
+
+func TestAccountUpdateRequiresIfMatch(t *testing.T) {
+	acc := prepareAccount("versioned", "versionedPASS1")
+	url := fmt.Sprintf("/api/accounts/%s", acc.ID.Hex())
+	read := requestIfMatch("GET", url, "", nil)
+	tag := read.Header().Get("ETag")
+	if tag != accountETag(acc) {
+		t.Fatalf("account must be read with ETag, got %q", tag)
+	}
+
+	external := true
+	update := AccountUpdateData{IsExternalAccount: &external}
+	if rr := requestIfMatch("PATCH", url, "", update); rr.Body.String() != problemBody(428, "if_match_required", ErrIfMatchRequired.Message) {
+		t.Errorf("update without If-Match must be rejected, got %v", rr.Body.String())
+	}
+	rr := requestIfMatch("PATCH", url, tag, update)
+	if rr.Code != 200 || rr.Header().Get("ETag") == tag || rr.Header().Get("ETag") != accountETag(acc) {
+		t.Fatalf("update must answer with new ETag, got %v %v %v", rr.Code, rr.Header().Get("ETag"), rr.Body.String())
+	}
+	if rr := requestIfMatch("PATCH", url, tag, update); rr.Body.String() != problemBody(412, "version_conflict", ErrVersionConflict.Message) {
+		t.Errorf("update with old ETag must be rejected, got %v", rr.Body.String())
+	}
+	if rr := requestIfMatch("PATCH", url, "*", update); rr.Code != 200 {
+		t.Errorf("any ETag must match *, got %v", rr.Body.String())
+	}
+}
+
+func TestPolicyUpdateRequiresIfMatch(t *testing.T) {
+	defer setTestPolicy(DEFAULT_POLICY)
+	url := "/api/accounts/password/policy"
+	tag := requestIfMatch("GET", url, "", nil).Header().Get("ETag")
+	if tag == "" {
+		t.Fatalf("policy must be read with ETag")
+	}
+	policy := PasswordPolicy{Length: 6, Numbers: true}
+	if rr := requestIfMatch("POST", url, "", policy); rr.Code != 428 {
+		t.Errorf("update without If-Match must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+	if rr := requestIfMatch("POST", url, tag, policy); rr.Code != 200 || rr.Header().Get("ETag") == tag {
+		t.Fatalf("policy must be updated with new ETag, got %v %v", rr.Code, rr.Body.String())
+	}
+	policy.Length = 5
+	if rr := requestIfMatch("POST", url, tag, policy); rr.Code != 412 {
+		t.Errorf("second update with the same ETag must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+}
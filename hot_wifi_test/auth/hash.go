@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrUnknownHash = errors.New("Unknown password hash format")
+
+// PasswordHasher produces and verifies encoded password hashes. Encoded hash carries
+// algorithm and parameters, so it can be verified after parameters were changed.
+type PasswordHasher interface {
+	Name() string
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// Supports reports that encoded hash was made by this algorithm.
+	Supports(encoded string) bool
+	// NeedsRehash reports that encoded hash was made with other parameters than current.
+	NeedsRehash(encoded string) bool
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+var b64 = base64.RawStdEncoding
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Name() string {
+	return "bcrypt"
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *BcryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// ScryptHasher encodes hashes as $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>
+type ScryptHasher struct {
+	LogN    int
+	R       int
+	P       int
+	KeyLen  int
+	SaltLen int
+}
+
+type scryptParams struct {
+	logN, r, p int
+	salt, key  []byte
+}
+
+func (h *ScryptHasher) Name() string {
+	return "scrypt"
+}
+
+func (h *ScryptHasher) Hash(password string) (string, error) {
+	salt, err := randomBytes(h.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<uint(h.LogN), h.R, h.P, h.KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", h.LogN, h.R, h.P, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (h *ScryptHasher) decode(encoded string) (*scryptParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return nil, ErrUnknownHash
+	}
+	p := scryptParams{}
+	_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &p.logN, &p.r, &p.p)
+	if err != nil {
+		return nil, ErrUnknownHash
+	}
+	if p.salt, err = b64.DecodeString(parts[3]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if p.key, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	return &p, nil
+}
+
+func (h *ScryptHasher) Verify(password, encoded string) (bool, error) {
+	p, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := scrypt.Key([]byte(password), p.salt, 1<<uint(p.logN), p.r, p.p, len(p.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h *ScryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$scrypt$")
+}
+
+func (h *ScryptHasher) NeedsRehash(encoded string) bool {
+	p, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return p.logN != h.LogN || p.r != h.R || p.p != h.P || len(p.key) != h.KeyLen || len(p.salt) != h.SaltLen
+}
+
+// Argon2idHasher encodes hashes in PHC format: $argon2id$v=19$m=<memory KiB>,t=<time>,p=<threads>$<salt>$<hash>
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen int
+}
+
+type argon2Params struct {
+	version      int
+	memory, time uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func (h *Argon2idHasher) Name() string {
+	return "argon2id"
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := randomBytes(h.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) decode(encoded string) (*argon2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHash
+	}
+	p := argon2Params{}
+	_, err := fmt.Sscanf(parts[2], "v=%d", &p.version)
+	if err != nil || p.version != argon2.Version {
+		return nil, ErrUnknownHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads)
+	if err != nil {
+		return nil, ErrUnknownHash
+	}
+	if p.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if p.key, err = b64.DecodeString(parts[5]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	return &p, nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	p, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h *Argon2idHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory != h.Memory || p.time != h.Time || p.threads != h.Threads || len(p.key) != int(h.KeyLen) || len(p.salt) != h.SaltLen
+}
+
+// legacySha1Hasher verifies unsalted sha1 hex hashes stored before hashers were introduced.
+// It never produces new hashes, such hashes are always upgraded to current hasher.
+type legacySha1Hasher struct{}
+
+func (h *legacySha1Hasher) Name() string {
+	return "sha1"
+}
+
+func (h *legacySha1Hasher) Hash(password string) (string, error) {
+	return "", errors.New("sha1 is only supported for verifying old hashes")
+}
+
+func (h *legacySha1Hasher) Verify(password, encoded string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(encoded)) == 1, nil
+}
+
+func (h *legacySha1Hasher) Supports(encoded string) bool {
+	_, err := hex.DecodeString(encoded)
+	return len(encoded) == sha1.Size*2 && err == nil
+}
+
+func (h *legacySha1Hasher) NeedsRehash(encoded string) bool {
+	return true
+}
+
+func NewPasswordHasher(name string) (PasswordHasher, error) {
+	switch name {
+	case "bcrypt":
+		return &BcryptHasher{Cost: bcrypt.DefaultCost}, nil
+	case "scrypt":
+		return &ScryptHasher{LogN: 15, R: 8, P: 1, KeyLen: 32, SaltLen: 16}, nil
+	case "argon2id", "":
+		return &Argon2idHasher{Time: 2, Memory: 19 * 1024, Threads: 1, KeyLen: 32, SaltLen: 16}, nil
+	}
+	return nil, fmt.Errorf("Unknown password hasher %s", name)
+}
+
+// PasswordHashing hashes new passwords with current hasher and verifies hashes
+// of any known algorithm.
+type PasswordHashing struct {
+	Current PasswordHasher
+	known   []PasswordHasher
+}
+
+func NewPasswordHashing(current PasswordHasher) *PasswordHashing {
+	known := []PasswordHasher{current}
+	for _, name := range []string{"argon2id", "bcrypt", "scrypt"} {
+		if name != current.Name() {
+			h, _ := NewPasswordHasher(name)
+			known = append(known, h)
+		}
+	}
+	known = append(known, &legacySha1Hasher{})
+	return &PasswordHashing{Current: current, known: known}
+}
+
+func (ph *PasswordHashing) Hash(password string) (string, error) {
+	return ph.Current.Hash(password)
+}
+
+func (ph *PasswordHashing) hasherFor(encoded string) PasswordHasher {
+	for _, h := range ph.known {
+		if h.Supports(encoded) {
+			return h
+		}
+	}
+	return nil
+}
+
+// Verify checks password against encoded hash. When password matches it also
+// reports whether hash must be replaced with hash of current hasher.
+func (ph *PasswordHashing) Verify(password, encoded string) (ok bool, rehash bool, err error) {
+	h := ph.hasherFor(encoded)
+	if h == nil {
+		return false, false, ErrUnknownHash
+	}
+	ok, err = h.Verify(password, encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, h.Name() != ph.Current.Name() || ph.Current.NeedsRehash(encoded), nil
+}
+
+func mustPasswordHashing(name string) *PasswordHashing {
+	h, err := NewPasswordHasher(name)
+	if err != nil {
+		panic(err)
+	}
+	return NewPasswordHashing(h)
+}
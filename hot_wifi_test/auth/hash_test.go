@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashers(t *testing.T) {
+	for _, name := range []string{"argon2id", "bcrypt", "scrypt"} {
+		h, err := NewPasswordHasher(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := h.Hash("secret")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if strings.Contains(encoded, "secret") || !h.Supports(encoded) || h.NeedsRehash(encoded) {
+			t.Errorf("%s: bad encoded hash %s", name, encoded)
+		}
+		if ok, err := h.Verify("secret", encoded); !ok || err != nil {
+			t.Errorf("%s: password must match: %v", name, err)
+		}
+		if ok, _ := h.Verify("Secret", encoded); ok {
+			t.Errorf("%s: other password must not match", name)
+		}
+		other, _ := h.Hash("secret")
+		if other == encoded {
+			t.Errorf("%s: hashes must be salted", name)
+		}
+	}
+}
+
+func TestHashersNeedsRehash(t *testing.T) {
+	weak := &ScryptHasher{LogN: 10, R: 8, P: 1, KeyLen: 32, SaltLen: 16}
+	encoded, _ := weak.Hash("secret")
+	strong, _ := NewPasswordHasher("scrypt")
+	if !strong.NeedsRehash(encoded) {
+		t.Errorf("hash with other parameters must be rehashed")
+	}
+	if ok, _ := strong.Verify("secret", encoded); !ok {
+		t.Errorf("hash with other parameters must be verified with its own parameters")
+	}
+}
+
+func TestPasswordHashing(t *testing.T) {
+	current, _ := NewPasswordHasher("argon2id")
+	ph := NewPasswordHashing(current)
+
+	bcryptHasher, _ := NewPasswordHasher("bcrypt")
+	old, _ := bcryptHasher.Hash("secret")
+	legacy := "e5e9fa1ba31ecd1ae84f75caaa474f3a663f05f4" // sha1("secret")
+
+	for _, encoded := range []string{old, legacy} {
+		ok, rehash, err := ph.Verify("secret", encoded)
+		if !ok || !rehash || err != nil {
+			t.Errorf("%s must be verified and rehashed: %v %v %v", encoded, ok, rehash, err)
+		}
+		ok, _, _ = ph.Verify("bad", encoded)
+		if ok {
+			t.Errorf("%s must not be verified with bad password", encoded)
+		}
+	}
+
+	encoded, _ := ph.Hash("secret")
+	ok, rehash, _ := ph.Verify("secret", encoded)
+	if !ok || rehash {
+		t.Errorf("current hash must be verified without rehash")
+	}
+
+	if _, _, err := ph.Verify("secret", "plain"); err != ErrUnknownHash {
+		t.Errorf("unknown hash must be rejected, got %v", err)
+	}
+}
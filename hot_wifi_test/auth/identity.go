@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+)
+
+var ErrExternalLoginTaken = NewError(KindConflict, "external_login_taken", "Login is taken by another account")
+var ErrBadExternalLogin = NewError(KindForbidden, "bad_external_login", "Login given by identity provider is not allowed")
+
+// ExternalIdentity is user confirmed by identity provider. Subject is stable id of
+// user at provider, Login is name of account in this service. LoginVerified tells that
+// provider confirmed login itself, not only that user may set it, e.g. LDAP bind by login
+// or verified email.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Login         string
+	LoginVerified bool
+}
+
+// IdentityProvider authenticates external accounts.
+type IdentityProvider interface {
+	Name() string
+}
+
+// PasswordIdentityProvider checks login and password, e.g. by LDAP bind. It returns
+// ErrBadCredentials if they are wrong.
+type PasswordIdentityProvider interface {
+	IdentityProvider
+	Authenticate(login, password string) (*ExternalIdentity, error)
+}
+
+// RedirectIdentityProvider authenticates user at provider site by authorization code
+// flow with PKCE, e.g. OpenID Connect.
+type RedirectIdentityProvider interface {
+	IdentityProvider
+	AuthCodeURL(state, verifier, nonce string) string
+	Exchange(ctx context.Context, code, verifier, nonce string) (*ExternalIdentity, error)
+}
+
+// provisionExternal returns account of identity and creates it at first login. External
+// account without provider is linked to first provider which verifies its login, other
+// identities get it only by provider and subject set by administrator. Local accounts
+// are never taken over. Created account must have login which POST /accounts accepts.
+func (sh *ServerHandler) provisionExternal(ctx context.Context, identity *ExternalIdentity) (*Account, error) {
+	acc, err := sh.findAccount(ctx, identity.Login)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		fe := fieldErrors{}
+		fe.login("login", identity.Login)
+		if len(fe) > 0 {
+			return nil, ErrBadExternalLogin
+		}
+		acc = &Account{
+			Login:             NormalizeLogin(identity.Login),
+			IsExternalAccount: true,
+			ExternalProvider:  identity.Provider,
+			ExternalSubject:   identity.Subject,
+		}
+		err = sh.accountsStorage.CreateAccount(ctx, acc)
+		if err != nil {
+			return nil, err
+		}
+		return acc, nil
+	} else if !acc.IsExternalAccount || sh.config.IsSupervisor(acc) {
+		return nil, ErrExternalLoginTaken
+	} else if acc.ExternalProvider != "" {
+		if acc.ExternalProvider != identity.Provider || acc.ExternalSubject != identity.Subject {
+			return nil, ErrExternalLoginTaken
+		}
+		return acc, nil
+	} else if !identity.LoginVerified {
+		return nil, ErrExternalLoginTaken
+	}
+	acc.ExternalProvider = identity.Provider
+	acc.ExternalSubject = identity.Subject
+	err = sh.accountsStorage.UpdateAccount(ctx, acc)
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+func (sh *ServerHandler) passwordProvider(name string) PasswordIdentityProvider {
+	for _, p := range sh.providers {
+		if pp, ok := p.(PasswordIdentityProvider); ok && (name == "" || p.Name() == name) {
+			return pp
+		}
+	}
+	return nil
+}
+
+// accountPasswordProvider returns provider of external account or any password
+// provider for unknown login and external account which is not linked yet. Local
+// accounts have no provider.
+func (sh *ServerHandler) accountPasswordProvider(acc *Account) PasswordIdentityProvider {
+	if acc != nil && !acc.IsExternalAccount {
+		return nil
+	}
+	if acc == nil || acc.ExternalProvider == "" {
+		return sh.passwordProvider("")
+	}
+	return sh.passwordProvider(acc.ExternalProvider)
+}
+
+func (sh *ServerHandler) redirectProvider(name string) RedirectIdentityProvider {
+	for _, p := range sh.providers {
+		if rp, ok := p.(RedirectIdentityProvider); ok && p.Name() == name {
+			return rp
+		}
+	}
+	return nil
+}
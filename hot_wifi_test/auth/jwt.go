@@ -0,0 +1,378 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidToken = NewError(KindUnauthenticated, "invalid_token", "Token is invalid")
+
+// SigningKey is key of access tokens. Private is []byte for HS256,
+// *rsa.PrivateKey for RS256 and ed25519.PrivateKey for EdDSA.
+type SigningKey struct {
+	Kid     string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+	retired time.Time
+}
+
+func NewHMACKey(kid string, secret []byte) *SigningKey {
+	return &SigningKey{Kid: kid, Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+}
+
+func NewRSAKey(kid string, key *rsa.PrivateKey) *SigningKey {
+	return &SigningKey{Kid: kid, Method: jwt.SigningMethodRS256, Private: key, Public: &key.PublicKey}
+}
+
+func NewEd25519Key(kid string, key ed25519.PrivateKey) *SigningKey {
+	return &SigningKey{Kid: kid, Method: jwt.SigningMethodEdDSA, Private: key, Public: key.Public()}
+}
+
+func newKid() string {
+	b, _ := randomBytes(8)
+	return fmt.Sprintf("%x", b)
+}
+
+// GenerateSigningKey makes new random key for HS256, RS256 or EdDSA.
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	switch alg {
+	case "HS256", "":
+		secret, err := randomBytes(32)
+		if err != nil {
+			return nil, err
+		}
+		return NewHMACKey(newKid(), secret), nil
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return NewRSAKey(newKid(), key), nil
+	case "EdDSA":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return NewEd25519Key(newKid(), key), nil
+	}
+	return nil, fmt.Errorf("Unknown signing algorithm %s", alg)
+}
+
+// LoadSigningKey reads PEM encoded RSA or Ed25519 private key (PKCS8 or PKCS1).
+func LoadSigningKey(kid, path string) (*SigningKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data in %s", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewRSAKey(kid, key), nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return NewRSAKey(kid, k), nil
+	case ed25519.PrivateKey:
+		return NewEd25519Key(kid, k), nil
+	}
+	return nil, fmt.Errorf("Unsupported key type in %s", path)
+}
+
+// KeyRing holds signing keys by kid. New tokens are signed by active key,
+// tokens signed by previous keys stay valid until their keys are retired.
+type KeyRing struct {
+	mu     sync.RWMutex
+	active string
+	keys   map[string]*SigningKey
+	order  []string
+}
+
+func NewKeyRing(active *SigningKey) *KeyRing {
+	kr := KeyRing{keys: map[string]*SigningKey{}}
+	kr.Rotate(active)
+	return &kr
+}
+
+// Add adds key which is used only for verifying tokens.
+func (kr *KeyRing) Add(key *SigningKey) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[key.Kid]; !ok {
+		kr.order = append(kr.order, key.Kid)
+	}
+	kr.keys[key.Kid] = key
+}
+
+// Rotate makes key active, previous keys are kept for verifying.
+func (kr *KeyRing) Rotate(key *SigningKey) {
+	kr.Add(key)
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if previous, ok := kr.keys[kr.active]; ok && kr.active != key.Kid {
+		previous.retired = time.Now()
+	}
+	kr.active = key.Kid
+}
+
+// Remove drops key, all tokens signed by it become invalid. Active key can not be removed.
+func (kr *KeyRing) Remove(kid string) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if kid == kr.active {
+		return
+	}
+	delete(kr.keys, kid)
+	for i, k := range kr.order {
+		if k == kid {
+			kr.order = append(kr.order[:i], kr.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (kr *KeyRing) Active() *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys[kr.active]
+}
+
+func (kr *KeyRing) Key(kid string) *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys[kid]
+}
+
+// AutoRotate generates new active key every period and removes keys which were
+// rotated out more than keep ago. It returns when done is closed.
+func (kr *KeyRing) AutoRotate(period, keep time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			key, err := GenerateSigningKey(kr.Active().Method.Alg())
+			if err != nil {
+				log.Printf("Error at generate signing key: %s", err)
+				continue
+			}
+			kr.Rotate(key)
+			log.Printf("Signing key rotated, new kid %s", key.Kid)
+			for _, old := range kr.retiredBefore(time.Now().Add(-keep)) {
+				kr.Remove(old)
+			}
+		}
+	}
+}
+
+func (kr *KeyRing) retiredBefore(t time.Time) []string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	result := []string{}
+	for kid, key := range kr.keys {
+		if !key.retired.IsZero() && key.retired.Before(t) {
+			result = append(result, kid)
+		}
+	}
+	return result
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns public keys of ring. Symmetric keys are never published.
+func (kr *KeyRing) JWKS() JWKS {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	enc := base64.RawURLEncoding
+	result := JWKS{Keys: []JWK{}}
+	for _, kid := range kr.order {
+		key := kr.keys[kid]
+		jwk := JWK{Kid: kid, Alg: key.Method.Alg(), Use: "sig"}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = enc.EncodeToString(pub.N.Bytes())
+			jwk.E = enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = enc.EncodeToString(pub)
+		default:
+			continue
+		}
+		result.Keys = append(result.Keys, jwk)
+	}
+	return result
+}
+
+// Claims of access token. ID (jti) is id of server side session.
+// MFA marks challenge token which only proves password and is exchanged for
+// access token after second factor.
+type Claims struct {
+	Login      string `json:"login"`
+	AccountID  string `json:"aid"`
+	Supervisor bool   `json:"sup"`
+	MFA        bool   `json:"mfa,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenIssuer signs access tokens. TTL is lifetime of access token,
+// RefreshTTL is lifetime of refresh token from its issue, MFATTL is lifetime
+// of challenge token. Tokens of SupervisorLogin are marked by sup claim.
+type TokenIssuer struct {
+	Keys            *KeyRing
+	TTL             time.Duration
+	RefreshTTL      time.Duration
+	MFATTL          time.Duration
+	Issuer          string
+	SupervisorLogin string
+}
+
+func (ti *TokenIssuer) Issue(account *Account, sessionID string) (string, error) {
+	return ti.issue(account, sessionID, ti.TTL, false)
+}
+
+// IssueMFA signs challenge token for second step of login.
+func (ti *TokenIssuer) IssueMFA(account *Account) (string, error) {
+	id, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	return ti.issue(account, id, ti.MFATTL, true)
+}
+
+func (ti *TokenIssuer) issue(account *Account, id string, ttl time.Duration, mfa bool) (string, error) {
+	key := ti.Keys.Active()
+	now := time.Now()
+	claims := Claims{
+		Login:      account.Login,
+		Supervisor: account.Login == ti.SupervisorLogin,
+		MFA:        mfa,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   account.Login,
+			Issuer:    ti.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	if account.ID != nil {
+		claims.AccountID = account.ID.Hex()
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.Private)
+}
+
+// Parse validates signature and expiry of access token and returns its claims.
+func (ti *TokenIssuer) Parse(token string) (*Claims, error) {
+	claims, err := ti.parse(token)
+	if err != nil || claims.MFA {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// ParseMFA validates challenge token of second step of login.
+func (ti *TokenIssuer) ParseMFA(token string) (*Claims, error) {
+	claims, err := ti.parse(token)
+	if err != nil || !claims.MFA {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (ti *TokenIssuer) parse(token string) (*Claims, error) {
+	claims := Claims{}
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key := ti.Keys.Key(kid)
+		if key == nil {
+			return nil, ErrInvalidToken
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, ErrInvalidToken
+		}
+		return key.Public, nil
+	}, jwt.WithIssuer(ti.Issuer), jwt.WithExpirationRequired(), jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// NewKeyRingFromEnv builds key ring by JWT_ALG and JWT_KEYS. JWT_KEYS is comma
+// separated list of kid:secret for HS256 or kid:path to PEM private key for RS256
+// and EdDSA, first key is active. Without JWT_KEYS random key is generated.
+func NewKeyRingFromEnv(alg, keys string) (*KeyRing, error) {
+	if keys == "" {
+		log.Printf("JWT_KEYS is not set, tokens will be signed by generated %s key", alg)
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyRing(key), nil
+	}
+
+	var kr *KeyRing
+	for _, item := range strings.Split(keys, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Bad JWT key %s, must be kid:value", item)
+		}
+		var key *SigningKey
+		var err error
+		switch alg {
+		case "HS256", "":
+			key = NewHMACKey(parts[0], []byte(parts[1]))
+		case "RS256", "EdDSA":
+			key, err = LoadSigningKey(parts[0], parts[1])
+		default:
+			err = fmt.Errorf("Unknown signing algorithm %s", alg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if kr == nil {
+			kr = NewKeyRing(key)
+		} else {
+			kr.Add(key)
+		}
+	}
+	return kr, nil
+}
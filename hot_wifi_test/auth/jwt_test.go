@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTokenIssuerAlgorithms(t *testing.T) {
+	id := primitive.NewObjectID()
+	acc := &Account{ID: &id, Login: "user"}
+	for _, alg := range []string{"HS256", "RS256", "EdDSA"} {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ti := TokenIssuer{Keys: NewKeyRing(key), TTL: time.Minute, Issuer: "test"}
+		token, err := ti.Issue(acc, "sid")
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		claims, err := ti.Parse(token)
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		if claims.Login != "user" || claims.AccountID != id.Hex() || claims.ID != "sid" || claims.Supervisor {
+			t.Errorf("%s: bad claims %v", alg, claims)
+		}
+
+		parts := strings.Split(token, ".")
+		tampered := parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))
+		if _, err := ti.Parse(tampered); err != ErrInvalidToken {
+			t.Errorf("%s: tampered token must be rejected", alg)
+		}
+	}
+}
+
+func TestTokenIssuerExpiry(t *testing.T) {
+	key, _ := GenerateSigningKey("HS256")
+	ti := TokenIssuer{Keys: NewKeyRing(key), TTL: -time.Second}
+	token, _ := ti.Issue(&Account{Login: "user"}, "sid")
+	if _, err := ti.Parse(token); err != ErrInvalidToken {
+		t.Errorf("expired token must be rejected")
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	first, _ := GenerateSigningKey("EdDSA")
+	ti := TokenIssuer{Keys: NewKeyRing(first), TTL: time.Minute}
+	old, _ := ti.Issue(&Account{Login: "user"}, "sid")
+
+	second, _ := GenerateSigningKey("EdDSA")
+	ti.Keys.Rotate(second)
+	fresh, _ := ti.Issue(&Account{Login: "user"}, "sid")
+
+	if _, err := ti.Parse(old); err != nil {
+		t.Errorf("token of previous key must be valid after rotation")
+	}
+	if _, err := ti.Parse(fresh); err != nil {
+		t.Errorf("token of active key must be valid")
+	}
+	if len(ti.Keys.JWKS().Keys) != 2 {
+		t.Errorf("both keys must be published")
+	}
+
+	ti.Keys.Remove(first.Kid)
+	if _, err := ti.Parse(old); err != ErrInvalidToken {
+		t.Errorf("token of removed key must be rejected")
+	}
+	ti.Keys.Remove(second.Kid)
+	if ti.Keys.Active() == nil {
+		t.Errorf("active key must not be removed")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	hs, _ := GenerateSigningKey("HS256")
+	rs, _ := GenerateSigningKey("RS256")
+	ed, _ := GenerateSigningKey("EdDSA")
+	kr := NewKeyRing(hs)
+	kr.Add(rs)
+	kr.Add(ed)
+
+	jwks := kr.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("only public keys must be published, got %v", jwks.Keys)
+	}
+	if jwks.Keys[0].Kty != "RSA" || jwks.Keys[0].Kid != rs.Kid || jwks.Keys[0].N == "" || jwks.Keys[0].E != "AQAB" {
+		t.Errorf("bad RSA jwk %v", jwks.Keys[0])
+	}
+	if jwks.Keys[1].Kty != "OKP" || jwks.Keys[1].Crv != "Ed25519" || jwks.Keys[1].X == "" {
+		t.Errorf("bad Ed25519 jwk %v", jwks.Keys[1])
+	}
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	rr := execResp(req)
+
+	var jwks JWKS
+	err := json.Unmarshal(rr.Body.Bytes(), &jwks)
+	if err != nil || jwks.Keys == nil {
+		t.Errorf("unexpected body: %v", rr.Body.String())
+	}
+}
+
+func TestFromTokenHonoursRevocation(t *testing.T) {
+	ctx := context.Background()
+	accounts := NewMemoryAccountsStorage()
+	manager := NewAuthManager(cfg, NewMemorySessionStorage(time.Minute), NewMemoryRefreshTokensStorage(), accounts, NewMemoryRolesStorage(), tokens)
+	acc := Account{Login: "revoked"}
+	accounts.CreateAccount(ctx, &acc)
+
+	sess, _ := manager.Login(ctx, &acc, "test", "127.0.0.1")
+	if found, _ := manager.FromToken(ctx, sess.Token); found == nil {
+		t.Fatalf("token must be valid")
+	}
+	manager.Logout(ctx, "revoked")
+	if found, _ := manager.FromToken(ctx, sess.Token); found != nil {
+		t.Errorf("token of deleted session must be rejected")
+	}
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPProvider authenticates by simple bind with DN made from normalised login by UserDN
+// template, e.g. "uid=%s,ou=people,dc=example,dc=com", so any case of login gets the
+// same subject.
+type LDAPProvider struct {
+	name    string
+	URL     string
+	UserDN  string
+	Timeout time.Duration
+}
+
+var _ PasswordIdentityProvider = (*LDAPProvider)(nil)
+
+func NewLDAPProvider(name, url, userDN string) *LDAPProvider {
+	return &LDAPProvider{name: name, URL: url, UserDN: userDN, Timeout: 5 * time.Second}
+}
+
+func (p *LDAPProvider) Name() string {
+	return p.name
+}
+
+func (p *LDAPProvider) Authenticate(login, password string) (*ExternalIdentity, error) {
+	login = NormalizeLogin(login)
+	// empty password makes unauthenticated bind which succeeds for any DN
+	if login == "" || password == "" {
+		return nil, ErrBadCredentials
+	}
+	conn, err := ldap.DialURL(p.URL, ldap.DialWithDialer(&net.Dialer{Timeout: p.Timeout}))
+	if err != nil {
+		log.Printf("Error at connect to ldap %s: %s", p.URL, err)
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(p.Timeout)
+
+	dn := fmt.Sprintf(p.UserDN, ldap.EscapeDN(login))
+	err = conn.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrBadCredentials
+	}
+	if err != nil {
+		log.Printf("Error at ldap bind of %s: %s", dn, err)
+		return nil, err
+	}
+	return &ExternalIdentity{Provider: p.name, Subject: dn, Login: login, LoginVerified: true}, nil
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapStub is LDAP server which answers only simple bind requests.
+type ldapStub struct {
+	listener  net.Listener
+	passwords map[string]string
+}
+
+func newLDAPStub(t *testing.T, passwords map[string]string) *ldapStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &ldapStub{listener: listener, passwords: passwords}
+	go stub.serve()
+	t.Cleanup(func() { listener.Close() })
+	return stub
+}
+
+func (s *ldapStub) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapStub) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *ldapStub) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		if op.Tag != ldap.ApplicationBindRequest {
+			return
+		}
+		dn := op.Children[1].Data.String()
+		password := op.Children[2].Data.String()
+		code := ldap.LDAPResultInvalidCredentials
+		if expected, ok := s.passwords[dn]; ok && expected == password {
+			code = ldap.LDAPResultSuccess
+		}
+
+		resp := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+		bind := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindResponse, nil, "")
+		bind.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+		bind.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		bind.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		resp.AppendChild(bind)
+		if _, err := conn.Write(resp.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func TestLDAPProvider(t *testing.T) {
+	stub := newLDAPStub(t, map[string]string{"uid=alice,ou=people,dc=example": "alicePASS1"})
+	provider := NewLDAPProvider("ldap", stub.URL(), "uid=%s,ou=people,dc=example")
+
+	identity, err := provider.Authenticate("alice", "alicePASS1")
+	if err != nil || identity.Login != "alice" || identity.Subject != "uid=alice,ou=people,dc=example" {
+		t.Fatalf("unexpected identity: %+v %v", identity, err)
+	}
+	if _, err := provider.Authenticate("alice", "wrong"); err != ErrBadCredentials {
+		t.Errorf("wrong password must be rejected, got %v", err)
+	}
+	if _, err := provider.Authenticate("alice", ""); err != ErrBadCredentials {
+		t.Errorf("empty password must be rejected without unauthenticated bind, got %v", err)
+	}
+	if _, err := provider.Authenticate("alice,ou=people,dc=example", "alicePASS1"); err != ErrBadCredentials {
+		t.Errorf("login must be escaped in DN, got %v", err)
+	}
+}
+
+func TestLDAPLoginProvisionsAccount(t *testing.T) {
+	ctx := context.Background()
+	stub := newLDAPStub(t, map[string]string{
+		"uid=ldapuser,ou=people,dc=example":   "ldapPASS1",
+		"uid=local,ou=people,dc=example":      "ldapPASS1",
+		"uid=precreated,ou=people,dc=example": "ldapPASS1",
+	})
+	ldapRouter := Router(cfg, as, ps, ss, rs, rls, ls, aus, tokens, NewLDAPProvider("ldap", stub.URL(), "uid=%s,ou=people,dc=example"))
+	login := func(login, password string) LoginResponse {
+		data, _ := json.Marshal(&LoginData{Login: login, Password: password})
+		req, _ := http.NewRequest("POST", "/api/accounts/login", bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "127.0.0.1:12345"
+		rr := httptest.NewRecorder()
+		ldapRouter.ServeHTTP(rr, req)
+		var resp LoginResponse
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+
+	if resp := login("ldapuser", "ldapPASS1"); !resp.OK || resp.Token == "" {
+		t.Fatalf("ldap account must login, got %+v", resp)
+	}
+	acc, _ := as.GetAccount(ctx, "ldapuser")
+	if acc == nil || !acc.IsExternalAccount || acc.ExternalProvider != "ldap" || acc.PasswordHash != "" {
+		t.Fatalf("external account must be provisioned without password, got %+v", acc)
+	}
+	if resp := login("ldapuser", "ldapPASS1"); !resp.OK {
+		t.Errorf("provisioned account must login again")
+	}
+	if resp := login("LdapUser", "ldapPASS1"); !resp.OK {
+		t.Errorf("provisioned account must login with any case of login")
+	}
+	if resp := login("ldapuser", "wrong"); resp.OK {
+		t.Errorf("wrong ldap password must be rejected")
+	}
+
+	data, _ := json.Marshal(&AccountCreateData{Login: "precreated", IsExternalAccount: true})
+	req, _ := http.NewRequest("POST", "/accounts", bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, sToken)
+	if rr := execResp(req); rr.Code != 200 {
+		t.Fatalf("external account must be created without password, got %v %v", rr.Code, rr.Body.String())
+	}
+	if resp := login("precreated", "ldapPASS1"); !resp.OK || resp.Token == "" {
+		t.Fatalf("pre-created external account must login through ldap, got %+v", resp)
+	}
+	if acc, _ := as.GetAccount(ctx, "precreated"); acc.ExternalProvider != "ldap" || acc.PasswordHash != "" {
+		t.Errorf("pre-created account must be linked to ldap, got %+v", acc)
+	}
+
+	prepareAccount("local", "localPASS1")
+	if resp := login("local", "ldapPASS1"); resp.OK {
+		t.Errorf("ldap must not take over local account")
+	}
+	if resp := login("local", "localPASS1"); !resp.OK {
+		t.Errorf("local account must login with own password")
+	}
+}
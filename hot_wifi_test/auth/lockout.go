@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrBadCredentials = NewError(KindUnauthenticated, "bad_credentials", "Bad login or password")
+var ErrAccountLocked = NewError(KindRateLimited, "account_locked", "Too many failed login attempts, try later")
+var ErrTooManyRequests = NewError(KindRateLimited, "too_many_requests", "Too many login attempts from your address, try later")
+
+// Lockout counts failed logins. Counting is done by login as it was sent, so unknown
+// logins are locked in the same way as existing ones and lockout does not reveal them.
+type Lockout struct {
+	Login       string    `json:"login" bson:"login"`
+	Failures    int       `json:"failures" bson:"failures"`
+	LastFailure time.Time `json:"lastFailure" bson:"last_failure"`
+	LockedUntil time.Time `json:"lockedUntil" bson:"locked_until"`
+}
+
+func (l *Lockout) IsLocked(now time.Time) bool {
+	return now.Before(l.LockedUntil)
+}
+
+// LockDuration grows twice with every failure after Threshold up to MaxDuration.
+func (lc *LockoutConfig) LockDuration(failures int) time.Duration {
+	if failures < lc.Threshold {
+		return 0
+	}
+	duration := time.Duration(lc.Duration) * time.Second
+	max := time.Duration(lc.MaxDuration) * time.Second
+	for i := lc.Threshold; i < failures && duration < max; i++ {
+		duration *= 2
+	}
+	if duration > max {
+		return max
+	}
+	return duration
+}
+
+var dummyHashOnce sync.Once
+var dummyHash string
+
+// verifyDummyPassword spends the same time as password check of existing account,
+// so response time does not reveal unknown logins.
+func verifyDummyPassword(hashing *PasswordHashing, password string) {
+	dummyHashOnce.Do(func() {
+		hash, err := hashing.Hash("dummy password for unknown logins")
+		if err != nil {
+			log.Printf("Error at hash dummy password: %s", err)
+		}
+		dummyHash = hash
+	})
+	hashing.Verify(password, dummyHash)
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// RateLimiter allows limit of calls per window for every key. Zero limit disables it.
+type RateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	now     func() time.Time
+	sweep   time.Time
+	windows map[string]*rateWindow
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, now: time.Now, windows: map[string]*rateWindow{}}
+}
+
+func (rl *RateLimiter) Allow(key string) bool {
+	if rl.limit <= 0 {
+		return true
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	if now.After(rl.sweep) {
+		for k, w := range rl.windows {
+			if now.Sub(w.start) >= rl.window {
+				delete(rl.windows, k)
+			}
+		}
+		rl.sweep = now.Add(rl.window)
+	}
+	w, ok := rl.windows[key]
+	if !ok || now.Sub(w.start) >= rl.window {
+		rl.windows[key] = &rateWindow{start: now, count: 1}
+		return true
+	}
+	if w.count >= rl.limit {
+		return false
+	}
+	w.count++
+	return true
+}
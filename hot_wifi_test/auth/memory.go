@@ -0,0 +1,558 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// In-memory storages. They keep the same semantics as mongo ones (unique logins,
+// expiring sessions, default policy when nothing stored) and are safe for
+// concurrent use. Useful for tests and small deployments without mongo.
+
+type MemoryAccountsStorage struct {
+	mu      sync.RWMutex
+	byId    map[primitive.ObjectID]*Account
+	byLogin map[string]primitive.ObjectID
+	byKey   map[string]primitive.ObjectID
+	order   []primitive.ObjectID
+}
+
+type MemoryPolicyStorage struct {
+	mu     sync.RWMutex
+	policy *PasswordPolicy
+}
+
+type MemoryRolesStorage struct {
+	mu    sync.RWMutex
+	roles map[string]Role
+}
+
+type MemoryLockoutsStorage struct {
+	mu       sync.Mutex
+	reset    time.Duration
+	now      func() time.Time
+	lockouts map[string]*Lockout
+}
+
+type MemoryAuditStorage struct {
+	mu     sync.RWMutex
+	events []AuditEvent
+}
+
+type memorySession struct {
+	session Session
+	expires time.Time
+}
+
+type MemorySessionsStorage struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	byId    map[string]*memorySession
+	byLogin map[string]map[string]bool
+}
+
+type MemoryRefreshTokensStorage struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	tokens map[string]*RefreshToken
+}
+
+var _ AccountsStorage = (*MemoryAccountsStorage)(nil)
+var _ PolicyStorage = (*MemoryPolicyStorage)(nil)
+var _ SessionsStorage = (*MemorySessionsStorage)(nil)
+var _ RefreshTokensStorage = (*MemoryRefreshTokensStorage)(nil)
+var _ RolesStorage = (*MemoryRolesStorage)(nil)
+var _ LockoutsStorage = (*MemoryLockoutsStorage)(nil)
+var _ AuditStorage = (*MemoryAuditStorage)(nil)
+
+func NewMemoryAccountsStorage() *MemoryAccountsStorage {
+	return &MemoryAccountsStorage{
+		byId:    map[primitive.ObjectID]*Account{},
+		byLogin: map[string]primitive.ObjectID{},
+		byKey:   map[string]primitive.ObjectID{},
+	}
+}
+
+func NewMemoryPolicyStorage() *MemoryPolicyStorage {
+	return &MemoryPolicyStorage{}
+}
+
+func NewMemoryRolesStorage() *MemoryRolesStorage {
+	return &MemoryRolesStorage{roles: map[string]Role{}}
+}
+
+func NewMemoryLockoutsStorage(reset time.Duration) *MemoryLockoutsStorage {
+	return &MemoryLockoutsStorage{reset: reset, now: time.Now, lockouts: map[string]*Lockout{}}
+}
+
+func NewMemoryAuditStorage() *MemoryAuditStorage {
+	return &MemoryAuditStorage{}
+}
+
+func NewMemorySessionStorage(ttl time.Duration) *MemorySessionsStorage {
+	return &MemorySessionsStorage{
+		ttl:     ttl,
+		now:     time.Now,
+		byId:    map[string]*memorySession{},
+		byLogin: map[string]map[string]bool{},
+	}
+}
+
+func NewMemoryRefreshTokensStorage() *MemoryRefreshTokensStorage {
+	return &MemoryRefreshTokensStorage{now: time.Now, tokens: map[string]*RefreshToken{}}
+}
+
+func copyAccount(acc *Account) *Account {
+	result := *acc
+	if acc.ID != nil {
+		id := *acc.ID
+		result.ID = &id
+	}
+	result.PasswordHistory = append([]string(nil), acc.PasswordHistory...)
+	result.Roles = append([]string(nil), acc.Roles...)
+	result.RecoveryCodes = append([]string(nil), acc.RecoveryCodes...)
+	return &result
+}
+
+func (st *MemoryAccountsStorage) CreateAccount(ctx context.Context, account *Account) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.byLogin[account.Login]; ok {
+		return ErrLoginAlreadyExists
+	}
+	if _, ok := st.byKey[account.IdempotencyKey]; ok && account.IdempotencyKey != "" {
+		return ErrIdempotencyKeyReused
+	}
+	id := primitive.NewObjectID()
+	account.ID = &id
+	st.byId[id] = copyAccount(account)
+	st.byLogin[account.Login] = id
+	if account.IdempotencyKey != "" {
+		st.byKey[account.IdempotencyKey] = id
+	}
+	st.order = append(st.order, id)
+	return nil
+}
+
+func (st *MemoryAccountsStorage) UpdateAccount(ctx context.Context, account *Account) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if account.ID == nil {
+		return ErrAccountNotFound
+	}
+	old, ok := st.byId[*account.ID]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if old.Version != account.Version {
+		return ErrVersionConflict
+	}
+	if id, ok := st.byLogin[account.Login]; ok && id != *account.ID {
+		return ErrLoginAlreadyExists
+	}
+	delete(st.byLogin, old.Login)
+	account.Version++
+	st.byId[*account.ID] = copyAccount(account)
+	st.byLogin[account.Login] = *account.ID
+	return nil
+}
+
+func (st *MemoryAccountsStorage) GetAccount(ctx context.Context, login string) (*Account, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	id, ok := st.byLogin[login]
+	if !ok {
+		return nil, nil
+	}
+	return copyAccount(st.byId[id]), nil
+}
+
+func (st *MemoryAccountsStorage) GetAccountById(ctx context.Context, id string) (*Account, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	acc, ok := st.byId[objectID]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	return copyAccount(acc), nil
+}
+
+func (st *MemoryAccountsStorage) GetAccountsViews(ctx context.Context) ([]AccountView, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	result := []AccountView{}
+	for _, id := range st.order {
+		acc := st.byId[id]
+		accId := id
+		result = append(result, AccountView{ID: &accId, Login: acc.Login, IsExternalAccount: acc.IsExternalAccount, Roles: append([]string(nil), acc.Roles...)})
+	}
+	return result, nil
+}
+
+func (st *MemoryAccountsStorage) DeleteAccount(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	acc, ok := st.byId[objectID]
+	if !ok {
+		return nil
+	}
+	delete(st.byId, objectID)
+	delete(st.byLogin, acc.Login)
+	delete(st.byKey, acc.IdempotencyKey)
+	for i, stored := range st.order {
+		if stored == objectID {
+			st.order = append(st.order[:i], st.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (st *MemoryPolicyStorage) SetPolicy(ctx context.Context, p *PasswordPolicy) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	version := int64(0)
+	if st.policy != nil {
+		version = st.policy.Version
+	}
+	if p.Version != version {
+		return ErrVersionConflict
+	}
+	p.Version++
+	policy := *p
+	st.policy = &policy
+	return nil
+}
+
+func (st *MemoryPolicyStorage) GetPolicy(ctx context.Context) (*PasswordPolicy, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	policy := *DEFAULT_POLICY
+	if st.policy != nil {
+		policy = *st.policy
+	}
+	return &policy, nil
+}
+
+func copyRole(role Role) *Role {
+	role.Permissions = append([]string(nil), role.Permissions...)
+	return &role
+}
+
+func (st *MemoryRolesStorage) SetRole(ctx context.Context, role *Role) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.roles[role.Name] = *copyRole(*role)
+	return nil
+}
+
+func (st *MemoryRolesStorage) GetRole(ctx context.Context, name string) (*Role, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	role, ok := st.roles[name]
+	if !ok {
+		return nil, ErrRoleNotFound
+	}
+	return copyRole(role), nil
+}
+
+func (st *MemoryRolesStorage) GetRoles(ctx context.Context) ([]Role, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	result := []Role{}
+	for _, role := range st.roles {
+		result = append(result, *copyRole(role))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (st *MemoryRolesStorage) DeleteRole(ctx context.Context, name string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.roles, name)
+	return nil
+}
+
+func (st *MemoryAuditStorage) AddEvent(ctx context.Context, event *AuditEvent) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.events = append(st.events, *event)
+	return nil
+}
+
+func (st *MemoryAuditStorage) FindEvents(ctx context.Context, filter *AuditFilter) ([]AuditEvent, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	result := []AuditEvent{}
+	skipped := 0
+	for i := len(st.events) - 1; i >= 0 && len(result) < filter.Limit; i-- {
+		if !filter.Match(&st.events[i]) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		result = append(result, st.events[i])
+	}
+	return result, nil
+}
+
+// getAlive returns lockout of login and drops it if it is expired. Must be called under lock.
+func (st *MemoryLockoutsStorage) getAlive(login string) *Lockout {
+	lockout, ok := st.lockouts[login]
+	if !ok {
+		return nil
+	}
+	if st.now().Sub(lockout.LastFailure) >= st.reset {
+		delete(st.lockouts, login)
+		return nil
+	}
+	return lockout
+}
+
+func (st *MemoryLockoutsStorage) AddFailure(ctx context.Context, login string, at time.Time) (*Lockout, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	lockout := st.getAlive(login)
+	if lockout == nil {
+		lockout = &Lockout{Login: login}
+		st.lockouts[login] = lockout
+	}
+	lockout.Failures++
+	lockout.LastFailure = at
+	result := *lockout
+	return &result, nil
+}
+
+func (st *MemoryLockoutsStorage) SetLockedUntil(ctx context.Context, login string, until time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if lockout := st.getAlive(login); lockout != nil {
+		lockout.LockedUntil = until
+	}
+	return nil
+}
+
+func (st *MemoryLockoutsStorage) GetLockout(ctx context.Context, login string) (*Lockout, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	lockout := st.getAlive(login)
+	if lockout == nil {
+		return nil, nil
+	}
+	result := *lockout
+	return &result, nil
+}
+
+func (st *MemoryLockoutsStorage) GetLockouts(ctx context.Context) ([]Lockout, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	result := []Lockout{}
+	for login := range st.lockouts {
+		if lockout := st.getAlive(login); lockout != nil {
+			result = append(result, *lockout)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Login < result[j].Login })
+	return result, nil
+}
+
+func (st *MemoryLockoutsStorage) DeleteLockout(ctx context.Context, login string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.lockouts, login)
+	return nil
+}
+
+// getAlive returns not expired session by id and drops it if expired. Must be called under lock.
+func (st *MemorySessionsStorage) getAlive(id string) *memorySession {
+	stored, ok := st.byId[id]
+	if !ok {
+		return nil
+	}
+	if !st.now().Before(stored.expires) {
+		st.remove(id)
+		return nil
+	}
+	return stored
+}
+
+// remove drops session from indexes. Must be called under lock.
+func (st *MemorySessionsStorage) remove(id string) {
+	stored, ok := st.byId[id]
+	if !ok {
+		return
+	}
+	delete(st.byId, id)
+	delete(st.byLogin[stored.session.Login], id)
+	if len(st.byLogin[stored.session.Login]) == 0 {
+		delete(st.byLogin, stored.session.Login)
+	}
+}
+
+func (st *MemorySessionsStorage) SetSession(ctx context.Context, session *Session) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stored := &memorySession{session: *session, expires: st.now().Add(st.ttl)}
+	stored.session.Token = ""
+	st.byId[session.ID] = stored
+	if st.byLogin[session.Login] == nil {
+		st.byLogin[session.Login] = map[string]bool{}
+	}
+	st.byLogin[session.Login][session.ID] = true
+	return nil
+}
+
+func (st *MemorySessionsStorage) GetSession(ctx context.Context, id string) (*Session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stored := st.getAlive(id)
+	if stored == nil {
+		return nil, nil
+	}
+	s := stored.session
+	return &s, nil
+}
+
+func (st *MemorySessionsStorage) CountSessions(ctx context.Context) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	count := 0
+	for id := range st.byId {
+		if st.getAlive(id) != nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (st *MemorySessionsStorage) GetSessions(ctx context.Context, login string) ([]Session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	result := []Session{}
+	for id := range st.byLogin[login] {
+		if stored := st.getAlive(id); stored != nil {
+			result = append(result, stored.session)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+	return result, nil
+}
+
+func (st *MemorySessionsStorage) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if stored := st.getAlive(id); stored != nil {
+		stored.session.LastSeen = lastSeen
+		stored.expires = st.now().Add(st.ttl)
+	}
+	return nil
+}
+
+func (st *MemorySessionsStorage) DeleteSession(ctx context.Context, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.remove(id)
+	return nil
+}
+
+func (st *MemorySessionsStorage) DeleteSessions(ctx context.Context, login string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for id := range st.byLogin[login] {
+		st.remove(id)
+	}
+	return nil
+}
+
+func (st *MemoryRefreshTokensStorage) SetRefreshToken(ctx context.Context, token *RefreshToken) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stored := *token
+	st.tokens[token.Hash] = &stored
+	return nil
+}
+
+func (st *MemoryRefreshTokensStorage) GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stored, ok := st.tokens[hash]
+	if !ok {
+		return nil, nil
+	}
+	if !st.now().Before(stored.Expires) {
+		delete(st.tokens, hash)
+		return nil, nil
+	}
+	t := *stored
+	return &t, nil
+}
+
+func (st *MemoryRefreshTokensStorage) UseRefreshToken(ctx context.Context, hash string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stored, ok := st.tokens[hash]
+	if !ok || stored.Used {
+		return false, nil
+	}
+	stored.Used = true
+	return true, nil
+}
+
+func (st *MemoryRefreshTokensStorage) DeleteRefreshTokens(ctx context.Context, family string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for hash, stored := range st.tokens {
+		if stored.Family == family {
+			delete(st.tokens, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryAccountsUniqueLogin(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryAccountsStorage()
+
+	first := &Account{Login: "user", PasswordHash: "first"}
+	if err := st.CreateAccount(ctx, first); err != nil || first.ID == nil {
+		t.Fatalf("account must be inserted: %v %v", first.ID, err)
+	}
+	if err := st.CreateAccount(ctx, &Account{Login: "user", PasswordHash: "second"}); err != ErrLoginAlreadyExists {
+		t.Fatalf("taken login must be rejected, got %v", err)
+	}
+
+	views, _ := st.GetAccountsViews(ctx)
+	if len(views) != 1 {
+		t.Fatalf("must be one account, got %v", len(views))
+	}
+	acc, _ := st.GetAccount(ctx, "user")
+	if acc.PasswordHash != "first" {
+		t.Errorf("existing account must not be overwritten: %v", acc.PasswordHash)
+	}
+
+	st.CreateAccount(ctx, &Account{Login: "other"})
+	acc, _ = st.GetAccount(ctx, "other")
+	acc.Login = "user"
+	if err := st.UpdateAccount(ctx, acc); err != ErrLoginAlreadyExists {
+		t.Errorf("login of other account must be rejected, got %v", err)
+	}
+
+	if err := st.DeleteAccount(ctx, acc.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.GetAccountById(ctx, acc.ID.Hex()); err != ErrAccountNotFound {
+		t.Errorf("deleted account must not be found, got %v", err)
+	}
+	if acc, _ := st.GetAccount(ctx, "other"); acc != nil {
+		t.Errorf("deleted account must not be found by login")
+	}
+}
+
+func TestMemoryAccountsUpdate(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryAccountsStorage()
+	st.CreateAccount(ctx, &Account{Login: "first"})
+	st.CreateAccount(ctx, &Account{Login: "second"})
+	acc, _ := st.GetAccount(ctx, "first")
+
+	acc.Login = "second"
+	if err := st.UpdateAccount(ctx, acc); err != ErrLoginAlreadyExists {
+		t.Errorf("taken login must be rejected, got %v", err)
+	}
+	acc.Login = "renamed"
+	if err := st.UpdateAccount(ctx, acc); err != nil {
+		t.Fatal(err)
+	}
+	if old, _ := st.GetAccount(ctx, "first"); old != nil {
+		t.Errorf("old login must be free")
+	}
+	if renamed, _ := st.GetAccountById(ctx, acc.ID.Hex()); renamed.Login != "renamed" {
+		t.Errorf("account was not renamed: %v", renamed.Login)
+	}
+
+	stale := *acc
+	stale.Version--
+	if err := st.UpdateAccount(ctx, &stale); err != ErrVersionConflict {
+		t.Errorf("account of old version must be rejected, got %v", err)
+	}
+
+	st.DeleteAccount(ctx, acc.ID.Hex())
+	if err := st.UpdateAccount(ctx, acc); err != ErrAccountNotFound {
+		t.Errorf("deleted account must not be updated, got %v", err)
+	}
+}
+
+func TestMemoryAccountsReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryAccountsStorage()
+	st.CreateAccount(ctx, &Account{Login: "user", PasswordHash: "hash"})
+
+	acc, _ := st.GetAccount(ctx, "user")
+	acc.PasswordHash = "changed"
+
+	acc, _ = st.GetAccount(ctx, "user")
+	if acc.PasswordHash != "hash" {
+		t.Errorf("stored account must not be changed without UpdateAccount")
+	}
+}
+
+func TestMemoryAccountsConcurrent(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryAccountsStorage()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			login := fmt.Sprintf("user%d", i%10)
+			st.CreateAccount(ctx, &Account{Login: login})
+			st.GetAccount(ctx, login)
+			st.GetAccountsViews(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	views, _ := st.GetAccountsViews(ctx)
+	if len(views) != 10 {
+		t.Errorf("must be 10 unique accounts, got %v", len(views))
+	}
+}
+
+func TestMemorySessionsTtl(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	st := NewMemorySessionStorage(time.Minute)
+	st.now = func() time.Time { return now }
+
+	st.SetSession(ctx, &Session{ID: "token", Login: "user"})
+	if s, _ := st.GetSession(ctx, "token"); s == nil || s.Login != "user" {
+		t.Fatalf("session must be found, got %v", s)
+	}
+	now = now.Add(30 * time.Second)
+	st.TouchSession(ctx, "token", now)
+	now = now.Add(45 * time.Second)
+	if s, _ := st.GetSession(ctx, "token"); s == nil {
+		t.Fatalf("touched session must be prolonged")
+	}
+	if count, _ := st.CountSessions(ctx); count != 1 {
+		t.Errorf("alive session must be counted, got %d", count)
+	}
+
+	now = now.Add(time.Minute)
+	if count, _ := st.CountSessions(ctx); count != 0 {
+		t.Errorf("expired session must not be counted, got %d", count)
+	}
+	if s, _ := st.GetSession(ctx, "token"); s != nil {
+		t.Errorf("session must be expired, got %v", s)
+	}
+	if sessions, _ := st.GetSessions(ctx, "user"); len(sessions) != 0 {
+		t.Errorf("session must be expired, got %v", sessions)
+	}
+}
+
+func TestMemorySessionsManyPerLogin(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	st := NewMemorySessionStorage(time.Minute)
+	st.SetSession(ctx, &Session{ID: "first", Login: "user", Created: now})
+	st.SetSession(ctx, &Session{ID: "second", Login: "user", Created: now.Add(time.Second)})
+	st.SetSession(ctx, &Session{ID: "other", Login: "other", Created: now})
+
+	sessions, _ := st.GetSessions(ctx, "user")
+	if len(sessions) != 2 || sessions[0].ID != "first" || sessions[1].ID != "second" {
+		t.Fatalf("both sessions must be kept, got %v", sessions)
+	}
+
+	st.TouchSession(ctx, "first", now.Add(time.Hour))
+	if s, _ := st.GetSession(ctx, "first"); !s.LastSeen.Equal(now.Add(time.Hour)) {
+		t.Errorf("last seen must be updated, got %v", s.LastSeen)
+	}
+
+	st.DeleteSession(ctx, "first")
+	if s, _ := st.GetSession(ctx, "first"); s != nil {
+		t.Errorf("session must be deleted")
+	}
+	if s, _ := st.GetSession(ctx, "second"); s == nil {
+		t.Errorf("other session of login must be kept")
+	}
+
+	st.DeleteSessions(ctx, "user")
+	if sessions, _ := st.GetSessions(ctx, "user"); len(sessions) != 0 {
+		t.Errorf("all sessions must be deleted, got %v", sessions)
+	}
+	if s, _ := st.GetSession(ctx, "other"); s == nil {
+		t.Errorf("session of other login must be kept")
+	}
+}
+
+func TestMemoryPolicy(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryPolicyStorage()
+	if p, _ := st.GetPolicy(ctx); p.Length != DEFAULT_POLICY.Length || p.Version != 0 {
+		t.Errorf("default policy expected, got %v", p)
+	}
+	st.SetPolicy(ctx, &PasswordPolicy{Length: 10})
+	if p, _ := st.GetPolicy(ctx); p.Length != 10 || p.Version != 1 {
+		t.Errorf("stored policy expected, got %v", p)
+	}
+	if err := st.SetPolicy(ctx, &PasswordPolicy{Length: 12}); err != ErrVersionConflict {
+		t.Errorf("policy of old version must be rejected, got %v", err)
+	}
+}
+
+func TestMemoryLockoutsReset(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryLockoutsStorage(time.Hour)
+	now := time.Now()
+	st.now = func() time.Time { return now }
+
+	st.AddFailure(ctx, "user", now)
+	lockout, _ := st.AddFailure(ctx, "user", now)
+	if lockout.Failures != 2 {
+		t.Errorf("failures must be counted, got %v", lockout.Failures)
+	}
+	now = now.Add(time.Hour)
+	if lockout, _ := st.GetLockout(ctx, "user"); lockout != nil {
+		t.Errorf("failures must be forgotten after reset period")
+	}
+	if lockout, _ := st.AddFailure(ctx, "user", now); lockout.Failures != 1 {
+		t.Errorf("counting must start again, got %v", lockout.Failures)
+	}
+}
+
+func TestMemoryAccountsIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryAccountsStorage()
+	st.CreateAccount(ctx, &Account{Login: "first", IdempotencyKey: "key"})
+	if err := st.CreateAccount(ctx, &Account{Login: "second", IdempotencyKey: "key"}); err != ErrIdempotencyKeyReused {
+		t.Errorf("key of other account must be rejected, got %v", err)
+	}
+	if err := st.CreateAccount(ctx, &Account{Login: "third"}); err != nil {
+		t.Errorf("accounts without key must not conflict, got %v", err)
+	}
+
+	acc, _ := st.GetAccount(ctx, "first")
+	st.DeleteAccount(ctx, acc.ID.Hex())
+	if err := st.CreateAccount(ctx, &Account{Login: "second", IdempotencyKey: "key"}); err != nil {
+		t.Errorf("key of deleted account must be free, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	LoginLocked  = "locked"
+)
+
+// Metrics are collected in own registry, so every router exports only its metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	logins   *prometheus.CounterVec
+	storage  *prometheus.HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_http_requests_total",
+			Help: "Count of requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "auth_http_request_duration_seconds",
+			Help:    "Duration of requests by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_logins_total",
+			Help: "Count of logins by outcome: success, failure or locked.",
+		}, []string{"outcome"}),
+		storage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "auth_storage_operation_duration_seconds",
+			Help:    "Duration of storage operations by storage and operation.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"storage", "operation"}),
+	}
+	m.registry.MustRegister(m.requests, m.latency, m.logins, m.storage,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	for _, outcome := range []string{LoginSuccess, LoginFailure, LoginLocked} {
+		m.logins.WithLabelValues(outcome)
+	}
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts requests by path template of route, so ids do not make new series.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(req); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: res}
+		next.ServeHTTP(recorder, req)
+		m.latency.WithLabelValues(route, req.Method).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(route, req.Method, strconv.Itoa(recorder.Status())).Inc()
+	})
+}
+
+func (m *Metrics) Login(outcome string) {
+	m.logins.WithLabelValues(outcome).Inc()
+}
+
+// WatchSessions exports gauge of active sessions counted by storage at every scrape.
+func (m *Metrics) WatchSessions(sessionsStorage SessionsStorage) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "auth_active_sessions",
+		Help: "Count of sessions which are not expired or revoked.",
+	}, func() float64 {
+		count, err := sessionsStorage.CountSessions(context.Background())
+		if err != nil {
+			log.Printf("Error at count sessions for metrics: %s", err)
+			return 0
+		}
+		return float64(count)
+	}))
+}
+
+// observe returns func which records duration of storage operation when it is called.
+func (m *Metrics) observe(storage, operation string) func() {
+	start := time.Now()
+	return func() {
+		m.storage.WithLabelValues(storage, operation).Observe(time.Since(start).Seconds())
+	}
+}
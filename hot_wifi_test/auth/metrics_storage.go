@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// Instrumented storages bound every operation of wrapped storage by deadline and measure its latency.
+
+// operations is part of instrumented storage which is shared by all its methods.
+type operations struct {
+	storage string
+	metrics *Metrics
+	timeout time.Duration
+}
+
+// begin returns context of operation with deadline, returned func must be deferred.
+func (o *operations) begin(ctx context.Context, operation string) (context.Context, func()) {
+	observed := o.metrics.observe(o.storage, operation)
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	return ctx, func() {
+		cancel()
+		observed()
+	}
+}
+
+type instrumentedAccountsStorage struct {
+	AccountsStorage
+	operations
+}
+
+func (st *instrumentedAccountsStorage) CreateAccount(ctx context.Context, account *Account) error {
+	ctx, done := st.begin(ctx, "CreateAccount")
+	defer done()
+	return st.AccountsStorage.CreateAccount(ctx, account)
+}
+
+func (st *instrumentedAccountsStorage) GetAccount(ctx context.Context, login string) (*Account, error) {
+	ctx, done := st.begin(ctx, "GetAccount")
+	defer done()
+	return st.AccountsStorage.GetAccount(ctx, login)
+}
+
+func (st *instrumentedAccountsStorage) GetAccountById(ctx context.Context, id string) (*Account, error) {
+	ctx, done := st.begin(ctx, "GetAccountById")
+	defer done()
+	return st.AccountsStorage.GetAccountById(ctx, id)
+}
+
+func (st *instrumentedAccountsStorage) GetAccountsViews(ctx context.Context) ([]AccountView, error) {
+	ctx, done := st.begin(ctx, "GetAccountsViews")
+	defer done()
+	return st.AccountsStorage.GetAccountsViews(ctx)
+}
+
+func (st *instrumentedAccountsStorage) UpdateAccount(ctx context.Context, account *Account) error {
+	ctx, done := st.begin(ctx, "UpdateAccount")
+	defer done()
+	return st.AccountsStorage.UpdateAccount(ctx, account)
+}
+
+func (st *instrumentedAccountsStorage) DeleteAccount(ctx context.Context, id string) error {
+	ctx, done := st.begin(ctx, "DeleteAccount")
+	defer done()
+	return st.AccountsStorage.DeleteAccount(ctx, id)
+}
+
+type instrumentedSessionsStorage struct {
+	SessionsStorage
+	operations
+}
+
+func (st *instrumentedSessionsStorage) SetSession(ctx context.Context, session *Session) error {
+	ctx, done := st.begin(ctx, "SetSession")
+	defer done()
+	return st.SessionsStorage.SetSession(ctx, session)
+}
+
+func (st *instrumentedSessionsStorage) GetSession(ctx context.Context, id string) (*Session, error) {
+	ctx, done := st.begin(ctx, "GetSession")
+	defer done()
+	return st.SessionsStorage.GetSession(ctx, id)
+}
+
+func (st *instrumentedSessionsStorage) GetSessions(ctx context.Context, login string) ([]Session, error) {
+	ctx, done := st.begin(ctx, "GetSessions")
+	defer done()
+	return st.SessionsStorage.GetSessions(ctx, login)
+}
+
+func (st *instrumentedSessionsStorage) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+	ctx, done := st.begin(ctx, "TouchSession")
+	defer done()
+	return st.SessionsStorage.TouchSession(ctx, id, lastSeen)
+}
+
+func (st *instrumentedSessionsStorage) DeleteSession(ctx context.Context, id string) error {
+	ctx, done := st.begin(ctx, "DeleteSession")
+	defer done()
+	return st.SessionsStorage.DeleteSession(ctx, id)
+}
+
+func (st *instrumentedSessionsStorage) DeleteSessions(ctx context.Context, login string) error {
+	ctx, done := st.begin(ctx, "DeleteSessions")
+	defer done()
+	return st.SessionsStorage.DeleteSessions(ctx, login)
+}
+
+func (st *instrumentedSessionsStorage) CountSessions(ctx context.Context) (int, error) {
+	ctx, done := st.begin(ctx, "CountSessions")
+	defer done()
+	return st.SessionsStorage.CountSessions(ctx)
+}
+
+type instrumentedPolicyStorage struct {
+	PolicyStorage
+	operations
+}
+
+func (st *instrumentedPolicyStorage) SetPolicy(ctx context.Context, p *PasswordPolicy) error {
+	ctx, done := st.begin(ctx, "SetPolicy")
+	defer done()
+	return st.PolicyStorage.SetPolicy(ctx, p)
+}
+
+func (st *instrumentedPolicyStorage) GetPolicy(ctx context.Context) (*PasswordPolicy, error) {
+	ctx, done := st.begin(ctx, "GetPolicy")
+	defer done()
+	return st.PolicyStorage.GetPolicy(ctx)
+}
+
+type instrumentedRefreshTokensStorage struct {
+	RefreshTokensStorage
+	operations
+}
+
+func (st *instrumentedRefreshTokensStorage) SetRefreshToken(ctx context.Context, token *RefreshToken) error {
+	ctx, done := st.begin(ctx, "SetRefreshToken")
+	defer done()
+	return st.RefreshTokensStorage.SetRefreshToken(ctx, token)
+}
+
+func (st *instrumentedRefreshTokensStorage) GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	ctx, done := st.begin(ctx, "GetRefreshToken")
+	defer done()
+	return st.RefreshTokensStorage.GetRefreshToken(ctx, hash)
+}
+
+func (st *instrumentedRefreshTokensStorage) UseRefreshToken(ctx context.Context, hash string) (bool, error) {
+	ctx, done := st.begin(ctx, "UseRefreshToken")
+	defer done()
+	return st.RefreshTokensStorage.UseRefreshToken(ctx, hash)
+}
+
+func (st *instrumentedRefreshTokensStorage) DeleteRefreshTokens(ctx context.Context, family string) error {
+	ctx, done := st.begin(ctx, "DeleteRefreshTokens")
+	defer done()
+	return st.RefreshTokensStorage.DeleteRefreshTokens(ctx, family)
+}
+
+type instrumentedRolesStorage struct {
+	RolesStorage
+	operations
+}
+
+func (st *instrumentedRolesStorage) SetRole(ctx context.Context, role *Role) error {
+	ctx, done := st.begin(ctx, "SetRole")
+	defer done()
+	return st.RolesStorage.SetRole(ctx, role)
+}
+
+func (st *instrumentedRolesStorage) GetRole(ctx context.Context, name string) (*Role, error) {
+	ctx, done := st.begin(ctx, "GetRole")
+	defer done()
+	return st.RolesStorage.GetRole(ctx, name)
+}
+
+func (st *instrumentedRolesStorage) GetRoles(ctx context.Context) ([]Role, error) {
+	ctx, done := st.begin(ctx, "GetRoles")
+	defer done()
+	return st.RolesStorage.GetRoles(ctx)
+}
+
+func (st *instrumentedRolesStorage) DeleteRole(ctx context.Context, name string) error {
+	ctx, done := st.begin(ctx, "DeleteRole")
+	defer done()
+	return st.RolesStorage.DeleteRole(ctx, name)
+}
+
+type instrumentedLockoutsStorage struct {
+	LockoutsStorage
+	operations
+}
+
+func (st *instrumentedLockoutsStorage) AddFailure(ctx context.Context, login string, at time.Time) (*Lockout, error) {
+	ctx, done := st.begin(ctx, "AddFailure")
+	defer done()
+	return st.LockoutsStorage.AddFailure(ctx, login, at)
+}
+
+func (st *instrumentedLockoutsStorage) SetLockedUntil(ctx context.Context, login string, until time.Time) error {
+	ctx, done := st.begin(ctx, "SetLockedUntil")
+	defer done()
+	return st.LockoutsStorage.SetLockedUntil(ctx, login, until)
+}
+
+func (st *instrumentedLockoutsStorage) GetLockout(ctx context.Context, login string) (*Lockout, error) {
+	ctx, done := st.begin(ctx, "GetLockout")
+	defer done()
+	return st.LockoutsStorage.GetLockout(ctx, login)
+}
+
+func (st *instrumentedLockoutsStorage) GetLockouts(ctx context.Context) ([]Lockout, error) {
+	ctx, done := st.begin(ctx, "GetLockouts")
+	defer done()
+	return st.LockoutsStorage.GetLockouts(ctx)
+}
+
+func (st *instrumentedLockoutsStorage) DeleteLockout(ctx context.Context, login string) error {
+	ctx, done := st.begin(ctx, "DeleteLockout")
+	defer done()
+	return st.LockoutsStorage.DeleteLockout(ctx, login)
+}
+
+type instrumentedAuditStorage struct {
+	AuditStorage
+	operations
+}
+
+func (st *instrumentedAuditStorage) AddEvent(ctx context.Context, event *AuditEvent) error {
+	ctx, done := st.begin(ctx, "AddEvent")
+	defer done()
+	return st.AuditStorage.AddEvent(ctx, event)
+}
+
+func (st *instrumentedAuditStorage) FindEvents(ctx context.Context, filter *AuditFilter) ([]AuditEvent, error) {
+	ctx, done := st.begin(ctx, "FindEvents")
+	defer done()
+	return st.AuditStorage.FindEvents(ctx, filter)
+}
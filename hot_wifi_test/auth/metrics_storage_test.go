@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingAccountsStorage waits in GetAccount until context of operation is done.
+type blockingAccountsStorage struct {
+	*MemoryAccountsStorage
+	started chan struct{}
+	stopped chan error
+}
+
+func newBlockingAccountsStorage() *blockingAccountsStorage {
+	return &blockingAccountsStorage{NewMemoryAccountsStorage(), make(chan struct{}, 1), make(chan error, 1)}
+}
+
+func (st *blockingAccountsStorage) GetAccount(ctx context.Context, login string) (*Account, error) {
+	st.started <- struct{}{}
+	<-ctx.Done()
+	st.stopped <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestStorageOperationDeadline(t *testing.T) {
+	blocking := newBlockingAccountsStorage()
+	st := &instrumentedAccountsStorage{blocking, operations{"accounts", NewMetrics(), 10 * time.Millisecond}}
+
+	start := time.Now()
+	_, err := st.GetAccount(context.Background(), "user")
+	if err != context.DeadlineExceeded {
+		t.Errorf("operation must be stopped by deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("operation must be stopped at deadline, it took %v", elapsed)
+	}
+}
+
+func TestCancelledRequestStopsStorage(t *testing.T) {
+	blocking := newBlockingAccountsStorage()
+	r := Router(cfg, blocking, ps, ss, rs, rls, ls, aus, tokens)
+
+	data, _ := json.Marshal(&LoginData{Login: "user", Password: "userPASS1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "POST", "/api/accounts/login", bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	go func() {
+		<-blocking.started
+		cancel()
+	}()
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if err := <-blocking.stopped; err != context.Canceled {
+		t.Errorf("storage must see cancellation of request, got %v", err)
+	}
+	if rr.Body.String() != problemBody(400, "request_canceled", ErrRequestCanceled.Message) {
+		t.Errorf("cancelled login must not be answered as unavailable storage, got %v", rr.Body.String())
+	}
+	if sessions, _ := ss.GetSessions(context.Background(), "user"); len(sessions) != 0 {
+		t.Errorf("cancelled login must not create session, got %v", sessions)
+	}
+}
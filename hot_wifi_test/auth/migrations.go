@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration is one-shot change of stored data. Applied migrations are
+// remembered in migrations collection and never run again.
+type Migration struct {
+	Name  string
+	Apply func(ctx context.Context, db *mongo.Database) error
+}
+
+var Migrations = []Migration{
+	{Name: "scrub_plaintext_passwords", Apply: scrubPlaintextPasswords},
+	{Name: "rename_is_external_account", Apply: renameIsExternalAccount},
+	{Name: "drop_token_sessions", Apply: dropTokenSessions},
+	{Name: "drop_unique_session_login", Apply: dropUniqueSessionLogin},
+	{Name: "rename_policy_fields", Apply: renamePolicyFields},
+	{Name: "sliding_session_expiry", Apply: slidingSessionExpiry},
+}
+
+func Migrate(ctx context.Context, db *mongo.Database) error {
+	applied := db.Collection("migrations")
+	for _, m := range Migrations {
+		count, err := applied.CountDocuments(ctx, bson.M{"name": m.Name})
+		if err != nil {
+			log.Printf("Error at check migration %s: %s", m.Name, err)
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		log.Printf("Apply migration %s", m.Name)
+		err = m.Apply(ctx, db)
+		if err != nil {
+			log.Printf("Error at apply migration %s: %s", m.Name, err)
+			return err
+		}
+		_, err = applied.InsertOne(ctx, bson.M{"name": m.Name, "applied": time.Now()})
+		if err != nil {
+			log.Printf("Error at save migration %s: %s", m.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// scrubPlaintextPasswords removes cleartext passwords which were stored along with hashes.
+func scrubPlaintextPasswords(ctx context.Context, db *mongo.Database) error {
+	result, err := db.Collection("accounts").UpdateMany(
+		ctx,
+		bson.M{"password": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"password": ""}})
+	if err != nil {
+		return err
+	}
+	log.Printf("Plaintext passwords removed from %v accounts", result.ModifiedCount)
+	return nil
+}
+
+// renameIsExternalAccount moves external flag which was stored with lowercased key
+// because of malformed struct tag.
+func renameIsExternalAccount(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("accounts").UpdateMany(
+		ctx,
+		bson.M{"isexternalaccount": bson.M{"$exists": true}},
+		bson.M{"$rename": bson.M{"isexternalaccount": "isExternalAccount"}})
+	return err
+}
+
+// dropTokenSessions removes sessions keyed by opaque token, they can not be used with
+// signed access tokens. Users have to login again.
+func dropTokenSessions(ctx context.Context, db *mongo.Database) error {
+	return db.Collection("sessions").Drop(ctx)
+}
+
+// dropUniqueSessionLogin removes unique index which allowed only one session per login.
+// Not unique index with same name is created by sessions storage.
+func dropUniqueSessionLogin(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("sessions").Indexes().DropOne(ctx, "login_-1")
+	if err != nil {
+		log.Printf("Unique index of sessions login was not dropped: %s", err)
+	}
+	return nil
+}
+
+// renamePolicyFields moves policy switches which were stored with lowercased keys
+// because of malformed struct tags.
+func renamePolicyFields(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("policy").UpdateMany(
+		ctx,
+		bson.M{},
+		bson.M{"$rename": bson.M{
+			"uppercaseletters": "uppercase_letters",
+			"lowercaseletters": "lowercase_letters",
+			"specialsymbols":   "special_symbols",
+		}})
+	return err
+}
+
+// slidingSessionExpiry replaces ttl index on creation time of sessions with index on their
+// expiry time. Sessions stored without expiry time are dropped, users have to login again.
+func slidingSessionExpiry(ctx context.Context, db *mongo.Database) error {
+	sessions := db.Collection("sessions")
+	_, err := sessions.Indexes().DropOne(ctx, "created_-1")
+	if err != nil {
+		log.Printf("Ttl index of sessions creation was not dropped: %s", err)
+	}
+	_, err = sessions.DeleteMany(ctx, bson.M{"expires": bson.M{"$exists": false}})
+	return err
+}
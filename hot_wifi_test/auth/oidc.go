@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrBadIDToken = NewError(KindUnauthenticated, "bad_id_token", "Identity provider returned bad id token")
+
+// OIDCProvider authenticates by OpenID Connect authorization code flow with PKCE.
+// Login of account is taken from LoginClaim of id token, then from email and subject.
+// Only email with email_verified claim is verified login, user may set other claims.
+type OIDCProvider struct {
+	name       string
+	config     oauth2.Config
+	verifier   *oidc.IDTokenVerifier
+	LoginClaim string
+}
+
+var _ RedirectIdentityProvider = (*OIDCProvider)(nil)
+
+// NewOIDCProvider reads configuration of issuer from its discovery document.
+func NewOIDCProvider(ctx context.Context, name, issuer, clientID, clientSecret, redirectURL string) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCProvider{
+		name: name,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier:   provider.Verifier(&oidc.Config{ClientID: clientID}),
+		LoginClaim: "preferred_username",
+	}, nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, verifier, nonce string) string {
+	return p.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce))
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*ExternalIdentity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrBadIDToken
+	}
+	idToken, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrBadIDToken
+	}
+	claims := map[string]interface{}{}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+	identity := ExternalIdentity{Provider: p.name, Subject: idToken.Subject, Login: idToken.Subject}
+	for _, name := range []string{p.LoginClaim, "email"} {
+		if value, ok := claims[name].(string); ok && value != "" {
+			identity.Login = value
+			verified, _ := claims["email_verified"].(bool)
+			identity.LoginVerified = name == "email" && verified
+			break
+		}
+	}
+	return &identity, nil
+}
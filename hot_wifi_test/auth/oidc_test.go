@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeIssuer is OpenID Connect issuer which gives code "good-code" to user with
+// subject and name set by test. Challenge and nonce are taken from authorization request.
+type fakeIssuer struct {
+	server    *httptest.Server
+	key       *SigningKey
+	subject   string
+	username  string
+	email     string
+	verified  bool
+	challenge string
+	nonce     string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := GenerateSigningKey("RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi := &fakeIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                fi.server.URL,
+			"authorization_endpoint":                fi.server.URL + "/authorize",
+			"token_endpoint":                        fi.server.URL + "/token",
+			"jwks_uri":                              fi.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(NewKeyRing(fi.key).JWKS())
+	})
+	mux.HandleFunc("/token", fi.token)
+	fi.server = httptest.NewServer(mux)
+	t.Cleanup(fi.server.Close)
+	return fi
+}
+
+// authorize plays user consent: it remembers PKCE challenge and nonce of request.
+func (fi *fakeIssuer) authorize(authURL string) {
+	u, _ := url.Parse(authURL)
+	fi.challenge = u.Query().Get("code_challenge")
+	fi.nonce = u.Query().Get("nonce")
+}
+
+func (fi *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if r.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != fi.challenge {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                fi.server.URL,
+		"aud":                "client",
+		"sub":                fi.subject,
+		"nonce":              fi.nonce,
+		"preferred_username": fi.username,
+		"email":              fi.email,
+		"email_verified":     fi.verified,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Minute).Unix(),
+	}
+	token := jwt.NewWithClaims(fi.key.Method, claims)
+	token.Header["kid"] = fi.key.Kid
+	idToken, _ := token.SignedString(fi.key.Private)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+// oidcLoginFlow goes through login redirect and callback with given code and returns callback response.
+func oidcLoginFlow(t *testing.T, router http.Handler, fi *fakeIssuer, code string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/api/accounts/oidc/corp/login", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("login must redirect, got %v %v", rr.Code, rr.Body.String())
+	}
+	location := rr.Header().Get("Location")
+	fi.authorize(location)
+	u, _ := url.Parse(location)
+	if u.Query().Get("code_challenge_method") != "S256" {
+		t.Errorf("PKCE challenge must be sent, got %v", location)
+	}
+
+	callback := "/api/accounts/oidc/corp/callback?" + url.Values{"code": {code}, "state": {u.Query().Get("state")}}.Encode()
+	req, _ = http.NewRequest("GET", callback, nil)
+	for _, cookie := range rr.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	fi := newFakeIssuer(t)
+	provider, err := NewOIDCProvider(context.Background(), "corp", fi.server.URL, "client", "secret", "http://localhost/api/accounts/oidc/corp/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidcRouter := Router(cfg, as, ps, ss, rs, rls, ls, aus, tokens, provider)
+
+	fi.subject, fi.username = "sub-1", "oidcuser"
+	rr := oidcLoginFlow(t, oidcRouter, fi, "good-code")
+	var resp LoginResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if !resp.OK || resp.Token == "" {
+		t.Fatalf("callback must login, got %v", rr.Body.String())
+	}
+	acc, _ := as.GetAccount(ctx, "oidcuser")
+	if acc == nil || !acc.IsExternalAccount || acc.ExternalProvider != "corp" || acc.ExternalSubject != "sub-1" {
+		t.Fatalf("external account must be provisioned, got %+v", acc)
+	}
+
+	rr = oidcLoginFlow(t, oidcRouter, fi, "bad-code")
+	if body := rr.Body.String(); body != problemBody(401, "provider_refused", "Identity provider did not confirm login") {
+		t.Errorf("bad code must be rejected, got %v", body)
+	}
+
+	fi.subject = "sub-2"
+	rr = oidcLoginFlow(t, oidcRouter, fi, "good-code")
+	if body := rr.Body.String(); body != problemBody(409, "external_login_taken", "Login is taken by another account") {
+		t.Errorf("other subject must not take over account, got %v", body)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/accounts/oidc/corp/callback?code=good-code&state=forged", nil)
+	rr = httptest.NewRecorder()
+	oidcRouter.ServeHTTP(rr, req)
+	if body := rr.Body.String(); body != problemBody(400, "bad_oidc_state", "Login at identity provider is expired or forged, start again") {
+		t.Errorf("callback without flow cookie must be rejected, got %v", body)
+	}
+
+	fi.subject, fi.username = "sub-3", "a+b@x"
+	rr = oidcLoginFlow(t, oidcRouter, fi, "good-code")
+	if body := rr.Body.String(); body != problemBody(403, "bad_external_login", ErrBadExternalLogin.Message) {
+		t.Errorf("login which POST /accounts rejects must not be provisioned, got %v", body)
+	}
+	if acc, _ := as.GetAccount(ctx, "a+b@x"); acc != nil {
+		t.Errorf("account must not be created, got %+v", acc)
+	}
+}
+
+func TestOIDCLinksOnlyVerifiedLogin(t *testing.T) {
+	ctx := context.Background()
+	fi := newFakeIssuer(t)
+	provider, err := NewOIDCProvider(context.Background(), "corp", fi.server.URL, "client", "secret", "http://localhost/api/accounts/oidc/corp/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidcRouter := Router(cfg, as, ps, ss, rs, rls, ls, aus, tokens, provider)
+	login := func(subject, username, email string, verified bool) string {
+		fi.subject, fi.username, fi.email, fi.verified = subject, username, email, verified
+		return oidcLoginFlow(t, oidcRouter, fi, "good-code").Body.String()
+	}
+	for _, name := range []string{"privileged", "mail@example.com"} {
+		as.CreateAccount(ctx, &Account{Login: name, IsExternalAccount: true, Roles: []string{"admins"}})
+	}
+	taken := problemBody(409, "external_login_taken", ErrExternalLoginTaken.Message)
+
+	if body := login("attacker", "privileged", "", false); body != taken {
+		t.Errorf("username claim must not link existing account, got %v", body)
+	}
+	if body := login("attacker", "", "mail@example.com", false); body != taken {
+		t.Errorf("unverified email must not link existing account, got %v", body)
+	}
+	if acc, _ := as.GetAccount(ctx, "privileged"); acc.ExternalProvider != "" {
+		t.Fatalf("account must stay unlinked, got %+v", acc)
+	}
+
+	if body := login("owner", "", "mail@example.com", true); !strings.Contains(body, `"auth-token"`) {
+		t.Errorf("verified email must link account, got %v", body)
+	}
+
+	acc, _ := as.GetAccount(ctx, "privileged")
+	url := "/api/accounts/" + acc.ID.Hex()
+	body := requestWithToken("PATCH", url, sToken, map[string]string{"externalProvider": "corp", "externalSubject": "sub-privileged"}, "If-Match", accountETag(acc))
+	if !strings.Contains(body, `"externalProvider":"corp"`) {
+		t.Fatalf("administrator must link account, got %v", body)
+	}
+	if body := login("sub-privileged", "privileged", "", false); !strings.Contains(body, `"auth-token"`) {
+		t.Errorf("subject linked by administrator must login, got %v", body)
+	}
+	if body := login("attacker", "privileged", "", false); body != taken {
+		t.Errorf("other subject must not login, got %v", body)
+	}
+}
@@ -1,41 +1,196 @@
 package auth
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// PasswordPolicy is stored in PolicyStorage. Boolean class switches require at least
+// one symbol of class, Min* fields require given count of them. Ages are in seconds,
+// zero MaxAge means password TTL of config.
 type PasswordPolicy struct {
-	Length           int  `json:"length",bson:"length"`
-	Numbers          bool `json:"numbers",bson:"numbers"`
-	UppercaseLetters bool `json:"uppercase_letters",bson:"uppercase_letters"`
-	LowercaseLetters bool `json:"lowercase_letters",bson:"lowercase_letters"`
-	SpecialSymbols   bool `json:"special_symbols",bson:"special_symbols"`
+	Length           int      `json:"length" bson:"length"`
+	MaxLength        int      `json:"max_length" bson:"max_length"`
+	Numbers          bool     `json:"numbers" bson:"numbers"`
+	UppercaseLetters bool     `json:"uppercase_letters" bson:"uppercase_letters"`
+	LowercaseLetters bool     `json:"lowercase_letters" bson:"lowercase_letters"`
+	SpecialSymbols   bool     `json:"special_symbols" bson:"special_symbols"`
+	MinNumbers       int      `json:"min_numbers" bson:"min_numbers"`
+	MinUppercase     int      `json:"min_uppercase" bson:"min_uppercase"`
+	MinLowercase     int      `json:"min_lowercase" bson:"min_lowercase"`
+	MinSpecial       int      `json:"min_special" bson:"min_special"`
+	HistoryDepth     int      `json:"history_depth" bson:"history_depth"`
+	MinAge           int64    `json:"min_age" bson:"min_age"`
+	MaxAge           int64    `json:"max_age" bson:"max_age"`
+	BlockCommon      bool     `json:"block_common" bson:"block_common"`
+	Blocklist        []string `json:"blocklist" bson:"blocklist"`
+	DisallowLogin    bool     `json:"disallow_login" bson:"disallow_login"`
+	// Require2FA is "all" or "supervisors" to make TOTP mandatory for them.
+	Require2FA string `json:"require_2fa" bson:"require_2fa"`
+	// Version is incremented at every update, value from request is ignored.
+	Version int64 `json:"version" bson:"version"`
 }
 
-func checkRegexp(reg, toCheck string) bool {
-	r, _ := regexp.Compile(reg)
-	f := r.FindString(toCheck)
-	if len(f) == 0 {
-		return false
+func (p *PasswordPolicy) validateFields() []FieldError {
+	fe := fieldErrors{}
+	fe.check(p.Length >= 0 && p.Length <= maxPasswordLength, "length", "must be from 0 to 1024")
+	fe.check(p.MaxLength == 0 || p.MaxLength >= p.Length && p.MaxLength <= maxPasswordLength, "max_length", "must be 0 or from length to 1024")
+	fe.check(p.MinNumbers >= 0, "min_numbers", "must not be negative")
+	fe.check(p.MinUppercase >= 0, "min_uppercase", "must not be negative")
+	fe.check(p.MinLowercase >= 0, "min_lowercase", "must not be negative")
+	fe.check(p.MinSpecial >= 0, "min_special", "must not be negative")
+	// current password and kept history are checked
+	fe.check(p.HistoryDepth >= 0 && p.HistoryDepth <= maxPasswordHistory+1, "history_depth", "must be from 0 to 25")
+	fe.check(p.MinAge >= 0, "min_age", "must not be negative")
+	fe.check(p.MaxAge >= 0, "max_age", "must not be negative")
+	fe.check(p.MaxAge == 0 || p.MinAge < p.MaxAge, "min_age", "must be less than max_age")
+	fe.check(p.Require2FA == "" || p.Require2FA == Require2FAAll || p.Require2FA == Require2FASupervisors,
+		"require_2fa", `must be empty, "all" or "supervisors"`)
+	return fe
+}
+
+const (
+	Require2FAAll         = "all"
+	Require2FASupervisors = "supervisors"
+)
+
+// Requires2FA reports that account must use second factor. Supervisors are
+// supervisor account and accounts with any role.
+func (pp *PasswordPolicy) Requires2FA(acc *Account, supervisor bool) bool {
+	switch pp.Require2FA {
+	case Require2FAAll:
+		return true
+	case Require2FASupervisors:
+		return supervisor || len(acc.Roles) > 0
+	}
+	return false
+}
+
+// PolicyViolation is one violated rule of policy.
+type PolicyViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	ViolationMinLength     = "min_length"
+	ViolationMaxLength     = "max_length"
+	ViolationNumbers       = "numbers"
+	ViolationUppercase     = "uppercase_letters"
+	ViolationLowercase     = "lowercase_letters"
+	ViolationSpecial       = "special_symbols"
+	ViolationBlocklisted   = "blocklisted"
+	ViolationContainsLogin = "contains_login"
+	ViolationReused        = "reused"
+	ViolationMinAge        = "min_age"
+)
+
+var numbersRe = regexp.MustCompile("\\d")
+var uppercaseRe = regexp.MustCompile("\\p{Lu}")
+var lowercaseRe = regexp.MustCompile("\\p{Ll}")
+var specialRe = regexp.MustCompile("\\W")
+
+func countRegexp(r *regexp.Regexp, toCheck string) int {
+	return len(r.FindAllString(toCheck, -1))
+}
+
+// required returns count of symbols of class required by switch and minimal count.
+func required(switched bool, min int) int {
+	if switched && min < 1 {
+		return 1
 	}
-	return true
+	return min
+}
+
+func violation(code, format string, args ...interface{}) PolicyViolation {
+	return PolicyViolation{Code: code, Message: fmt.Sprintf(format, args...)}
 }
 
-func (pp *PasswordPolicy) CheckPassword(password string) bool {
-	if pp.Length > len(password) {
-		return false
+// CheckPassword returns rules of policy which are violated by password itself.
+func (pp *PasswordPolicy) CheckPassword(password string) []PolicyViolation {
+	result := []PolicyViolation{}
+	length := utf8.RuneCountInString(password)
+	if pp.Length > length {
+		result = append(result, violation(ViolationMinLength, "Password must be at least %d symbols long", pp.Length))
 	}
-	if pp.Numbers && !checkRegexp("\\d", password) {
-		return false
+	if pp.MaxLength > 0 && pp.MaxLength < length {
+		result = append(result, violation(ViolationMaxLength, "Password must be at most %d symbols long", pp.MaxLength))
 	}
-	if pp.LowercaseLetters && !checkRegexp("\\p{Ll}", password) {
-		return false
+	classes := []struct {
+		re   *regexp.Regexp
+		min  int
+		code string
+		name string
+	}{
+		{numbersRe, required(pp.Numbers, pp.MinNumbers), ViolationNumbers, "numbers"},
+		{uppercaseRe, required(pp.UppercaseLetters, pp.MinUppercase), ViolationUppercase, "uppercase letters"},
+		{lowercaseRe, required(pp.LowercaseLetters, pp.MinLowercase), ViolationLowercase, "lowercase letters"},
+		{specialRe, required(pp.SpecialSymbols, pp.MinSpecial), ViolationSpecial, "special symbols"},
 	}
-	if pp.SpecialSymbols && !checkRegexp("\\W", password) {
-		return false
+	for _, class := range classes {
+		if class.min > 0 && countRegexp(class.re, password) < class.min {
+			result = append(result, violation(class.code, "Password must contain at least %d %s", class.min, class.name))
+		}
 	}
-	if pp.UppercaseLetters && !checkRegexp("\\p{Lu}", password) {
-		return false
+	if pp.isBlocklisted(password) {
+		result = append(result, violation(ViolationBlocklisted, "Password is too common"))
 	}
-	return true
+	return result
 }
+
+func (pp *PasswordPolicy) isBlocklisted(password string) bool {
+	lower := strings.ToLower(password)
+	if pp.BlockCommon && commonPasswords[lower] {
+		return true
+	}
+	for _, blocked := range pp.Blocklist {
+		if strings.ToLower(blocked) == lower {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAccountPassword returns violated rules for new password of account: rules of
+// password itself, login containment and reuse of previous passwords.
+func (pp *PasswordPolicy) CheckAccountPassword(hashing *PasswordHashing, acc *Account, password string) []PolicyViolation {
+	result := pp.CheckPassword(password)
+	if pp.DisallowLogin && acc.Login != "" && strings.Contains(strings.ToLower(password), strings.ToLower(acc.Login)) {
+		result = append(result, violation(ViolationContainsLogin, "Password must not contain login"))
+	}
+	if pp.HistoryDepth > 0 && acc.PasswordHash != "" {
+		hashes := append([]string{acc.PasswordHash}, acc.PasswordHistory...)
+		if len(hashes) > pp.HistoryDepth {
+			hashes = hashes[:pp.HistoryDepth]
+		}
+		for _, hash := range hashes {
+			if ok, _, _ := hashing.Verify(password, hash); ok {
+				result = append(result, violation(ViolationReused, "Password must differ from %d last passwords", pp.HistoryDepth))
+				break
+			}
+		}
+	}
+	return result
+}
+
+// CheckPasswordAge returns violation if password of account is too young to be changed.
+func (pp *PasswordPolicy) CheckPasswordAge(acc *Account) []PolicyViolation {
+	result := []PolicyViolation{}
+	if pp.MinAge > 0 && acc.PasswordCreated+pp.MinAge > time.Now().Unix() {
+		result = append(result, violation(ViolationMinAge, "Password can be changed once in %d seconds", pp.MinAge))
+	}
+	return result
+}
+
+// PasswordMaxAge returns lifetime of password in seconds, defaultMaxAge if policy has not it.
+func (pp *PasswordPolicy) PasswordMaxAge(defaultMaxAge int) int64 {
+	if pp.MaxAge > 0 {
+		return pp.MaxAge
+	}
+	return int64(defaultMaxAge)
+}
+
+var DEFAULT_POLICY = &PasswordPolicy{Length: 4, LowercaseLetters: true, UppercaseLetters: true, Numbers: true}
@@ -1,35 +1,136 @@
 package auth
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestPolicySmoke(t *testing.T) {
 	p := PasswordPolicy{Length: 2}
-	if p.CheckPassword("1") {
+	if len(p.CheckPassword("1")) == 0 {
 		t.Error("Policy length not work")
 	}
 
 	p = PasswordPolicy{Numbers: true}
-	if p.CheckPassword("abc") {
+	if len(p.CheckPassword("abc")) == 0 {
 		t.Error("Policy numbers not work")
 	}
 
 	p = PasswordPolicy{UppercaseLetters: true}
-	if p.CheckPassword("abc") {
+	if len(p.CheckPassword("abc")) == 0 {
 		t.Error("Policy uppercase not work")
 	}
 
 	p = PasswordPolicy{LowercaseLetters: true}
-	if p.CheckPassword("ABC") {
+	if len(p.CheckPassword("ABC")) == 0 {
 		t.Error("Policy lowercase not work")
 	}
 
 	p = PasswordPolicy{SpecialSymbols: true}
-	if p.CheckPassword("abc") {
+	if len(p.CheckPassword("abc")) == 0 {
 		t.Error("Policy spec symbols not work")
 	}
 
 	p = PasswordPolicy{Length: 3, Numbers: true, UppercaseLetters: true, LowercaseLetters: true, SpecialSymbols: true}
-	if !p.CheckPassword("aA.1") {
+	if len(p.CheckPassword("aA.1")) != 0 {
 		t.Error("Policy not work")
 	}
 }
+
+func violationCodes(violations []PolicyViolation) map[string]bool {
+	result := map[string]bool{}
+	for _, v := range violations {
+		result[v.Code] = true
+	}
+	return result
+}
+
+func TestPolicyReportsAllViolations(t *testing.T) {
+	p := PasswordPolicy{Length: 8, MinNumbers: 2, MinUppercase: 2, SpecialSymbols: true}
+	codes := violationCodes(p.CheckPassword("Abc1"))
+	for _, code := range []string{ViolationMinLength, ViolationNumbers, ViolationUppercase, ViolationSpecial} {
+		if !codes[code] {
+			t.Errorf("violation %s must be reported, got %v", code, codes)
+		}
+	}
+	if codes[ViolationLowercase] {
+		t.Errorf("lowercase letters are not required")
+	}
+	if len(p.CheckPassword("ABcd12.!")) != 0 {
+		t.Errorf("password satisfies policy")
+	}
+}
+
+func TestPolicyMaxLength(t *testing.T) {
+	p := PasswordPolicy{MaxLength: 4}
+	if !violationCodes(p.CheckPassword("abcde"))[ViolationMaxLength] {
+		t.Errorf("too long password must violate policy")
+	}
+	if len(p.CheckPassword("абвг")) != 0 {
+		t.Errorf("length must be counted in symbols, not bytes")
+	}
+}
+
+func TestPolicyBlocklist(t *testing.T) {
+	p := PasswordPolicy{BlockCommon: true, Blocklist: []string{"HotWifi"}}
+	for _, pwd := range []string{"password", "Qwerty", "hotwifi"} {
+		if !violationCodes(p.CheckPassword(pwd))[ViolationBlocklisted] {
+			t.Errorf("password %s must be blocklisted", pwd)
+		}
+	}
+	if len(p.CheckPassword("correct horse battery")) != 0 {
+		t.Errorf("uncommon password must not be blocklisted")
+	}
+}
+
+func TestPolicyDisallowLogin(t *testing.T) {
+	p := PasswordPolicy{DisallowLogin: true}
+	acc := &Account{Login: "Alice"}
+	if !violationCodes(p.CheckAccountPassword(hashing, acc, "xxalice42"))[ViolationContainsLogin] {
+		t.Errorf("password containing login must violate policy")
+	}
+	if len(p.CheckAccountPassword(hashing, acc, "bob42")) != 0 {
+		t.Errorf("password without login must satisfy policy")
+	}
+}
+
+func TestPolicyHistory(t *testing.T) {
+	p := PasswordPolicy{HistoryDepth: 2}
+	acc := &Account{Login: "user"}
+	for _, pwd := range []string{"first", "second", "third"} {
+		if err := acc.SetNewPassword(hashing, pwd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pwd := range []string{"third", "second"} {
+		if !violationCodes(p.CheckAccountPassword(hashing, acc, pwd))[ViolationReused] {
+			t.Errorf("password %s is in history and must violate policy", pwd)
+		}
+	}
+	if len(p.CheckAccountPassword(hashing, acc, "first")) != 0 {
+		t.Errorf("password older than history depth must be allowed")
+	}
+}
+
+func TestPolicyAges(t *testing.T) {
+	p := PasswordPolicy{MinAge: 3600, MaxAge: 7200}
+	now := time.Now().Unix()
+	young := &Account{Login: "user", PasswordCreated: now - 60}
+	if !violationCodes(p.CheckPasswordAge(young))[ViolationMinAge] {
+		t.Errorf("too young password must not be changed")
+	}
+	old := &Account{Login: "user", PasswordCreated: now - 3601}
+	if len(p.CheckPasswordAge(old)) != 0 {
+		t.Errorf("password older than min age may be changed")
+	}
+	if old.IsPasswordExpired(p.PasswordMaxAge(cfg.PasswordTTL)) {
+		t.Errorf("password younger than max age must not be expired")
+	}
+	old.PasswordCreated = now - 7201
+	if !old.IsPasswordExpired(p.PasswordMaxAge(cfg.PasswordTTL)) {
+		t.Errorf("password older than max age must be expired")
+	}
+	if (&PasswordPolicy{}).PasswordMaxAge(cfg.PasswordTTL) != int64(cfg.PasswordTTL) {
+		t.Errorf("default max age must be password TTL of config")
+	}
+}
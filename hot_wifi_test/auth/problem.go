@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Kind is class of API error, it selects status of response.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindValidation
+	KindUnauthenticated
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindRateLimited
+	KindUnavailable
+	KindTooLarge
+	KindUnsupportedMediaType
+	KindPreconditionFailed
+	KindPreconditionRequired
+)
+
+var kindStatuses = map[Kind]int{
+	KindInternal:             http.StatusInternalServerError,
+	KindValidation:           http.StatusBadRequest,
+	KindUnauthenticated:      http.StatusUnauthorized,
+	KindForbidden:            http.StatusForbidden,
+	KindNotFound:             http.StatusNotFound,
+	KindConflict:             http.StatusConflict,
+	KindRateLimited:          http.StatusTooManyRequests,
+	KindUnavailable:          http.StatusServiceUnavailable,
+	KindTooLarge:             http.StatusRequestEntityTooLarge,
+	KindUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	KindPreconditionFailed:   http.StatusPreconditionFailed,
+	KindPreconditionRequired: http.StatusPreconditionRequired,
+}
+
+// Error is error of API. Code is stable and clients may rely on it, message is for humans
+// and may change. Errors with same code are same for errors.Is.
+type Error struct {
+	Kind       Kind
+	Code       string
+	Message    string
+	Violations []PolicyViolation
+	Fields     []FieldError
+	// Err is cause which is logged and never sent to client.
+	Err error
+}
+
+func NewError(kind Kind, code, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+func (e *Error) Status() int {
+	return kindStatuses[e.Kind]
+}
+
+// Wrap returns copy of error with cause.
+func (e *Error) Wrap(cause error) *Error {
+	result := *e
+	result.Err = cause
+	return &result
+}
+
+// WithFields returns copy of error with problems of request fields.
+func (e *Error) WithFields(fields []FieldError) *Error {
+	result := *e
+	result.Fields = fields
+	return &result
+}
+
+// WithViolations returns copy of error with violations of password policy.
+func (e *Error) WithViolations(violations []PolicyViolation) *Error {
+	result := *e
+	result.Violations = violations
+	return &result
+}
+
+var ErrInternal = NewError(KindInternal, "internal", "Internal error")
+var ErrStorageTimeout = NewError(KindUnavailable, "storage_timeout", "Storage did not answer in time")
+var ErrRequestCanceled = NewError(KindValidation, "request_canceled", "Request was canceled by client")
+var ErrMalformedBody = NewError(KindValidation, "malformed_body", "Request body is not valid JSON")
+var ErrMustLogin = NewError(KindUnauthenticated, "unauthenticated", "You must login")
+var ErrOnlySupervisor = NewError(KindForbidden, "supervisor_only", "It can do only supervisor")
+
+// ErrNoPermission is returned to accounts which have not permission through roles.
+func ErrNoPermission(permission string) *Error {
+	return NewError(KindForbidden, "permission_denied", "You have not permission %s", permission)
+}
+
+// Problem is RFC 7807 answer for error. Title is text of status, detail is message of error.
+type Problem struct {
+	Type       string            `json:"type"`
+	Title      string            `json:"title"`
+	Status     int               `json:"status"`
+	Detail     string            `json:"detail"`
+	Code       string            `json:"code"`
+	Violations []PolicyViolation `json:"violations,omitempty"`
+	Errors     []FieldError      `json:"errors,omitempty"`
+}
+
+// asError returns API error of err. Expired context means that storage was too slow,
+// cancelled one that client has gone, it is not logged. Other unknown errors are internal,
+// their text is only logged by WriteError.
+func asError(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrStorageTimeout.Wrap(err)
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrRequestCanceled.Wrap(err)
+	}
+	return ErrInternal.Wrap(err)
+}
+
+// WriteError answers with problem of err and status of its kind.
+func WriteError(w http.ResponseWriter, err error) {
+	apiErr := asError(err)
+	if (apiErr.Kind == KindInternal || apiErr.Kind == KindUnavailable) && apiErr.Err != nil {
+		log.Printf("Error %s: %s", apiErr.Code, apiErr.Err)
+	}
+	status := apiErr.Status()
+	res, err := json.Marshal(&Problem{Type: "about:blank", Title: http.StatusText(status), Status: status,
+		Detail: apiErr.Message, Code: apiErr.Code, Violations: apiErr.Violations, Errors: apiErr.Fields})
+	if err != nil {
+		log.Printf("Can not marshall error: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	WriteError(rr, ErrAccountNotFound)
+	if rr.Code != 404 || rr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("problem must be sent with status of kind, got %v %v", rr.Code, rr.Header())
+	}
+	if body := rr.Body.String(); body != `{"type":"about:blank","title":"Not Found","status":404,"detail":"Account not found","code":"account_not_found"}` {
+		t.Errorf("unexpected body: %v", body)
+	}
+
+	rr = httptest.NewRecorder()
+	WriteError(rr, errors.New("connection refused"))
+	if rr.Code != 500 || rr.Body.String() != problemBody(500, "internal", "Internal error") {
+		t.Errorf("unknown error must be internal without its text, got %v %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestContextErrorsAreUnavailable(t *testing.T) {
+	for _, cause := range []error{context.DeadlineExceeded, fmt.Errorf("find account: %w", context.DeadlineExceeded)} {
+		rr := httptest.NewRecorder()
+		WriteError(rr, cause)
+		if rr.Code != 503 || rr.Body.String() != problemBody(503, "storage_timeout", ErrStorageTimeout.Message) {
+			t.Errorf("%v must be answered as unavailable storage, got %v %v", cause, rr.Code, rr.Body.String())
+		}
+	}
+	rr := httptest.NewRecorder()
+	WriteError(rr, fmt.Errorf("find account: %w", context.Canceled))
+	if rr.Body.String() != problemBody(400, "request_canceled", ErrRequestCanceled.Message) {
+		t.Errorf("cancelled request must not be storage timeout, got %v %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestErrorIsByCode(t *testing.T) {
+	wrapped := ErrMalformedBody.Wrap(errors.New("unexpected EOF"))
+	if !errors.Is(wrapped, ErrMalformedBody) || errors.Is(wrapped, ErrInternal) {
+		t.Errorf("errors must be matched by code")
+	}
+	if asError(wrapped).Status() != 400 {
+		t.Errorf("wrapped error must keep its kind")
+	}
+}
+
+func TestStatusesOfHandlers(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/api/accounts/login", bytes.NewBufferString("{"))
+	if rr := execResp(req); rr.Code != 400 || rr.Body.String() != problemBody(400, "malformed_body", "Request body is not valid JSON") {
+		t.Errorf("malformed body must be rejected as bad request, got %v %v", rr.Code, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/accounts/sessions", nil)
+	if rr := execResp(req); rr.Code != 401 || rr.Body.String() != problemBody(401, "unauthenticated", "You must login") {
+		t.Errorf("request without token must be unauthenticated, got %v %v", rr.Code, rr.Body.String())
+	}
+
+	acc := prepareAccount("stranger", "strangerPASS1")
+	token := loginAs("stranger", "strangerPASS1")
+	other := prepareAccount("owner", "ownerPASS1")
+	body := putWithToken("/api/accounts/"+other.ID.Hex()+"/password", token, ChangePasswordData{Old: "ownerPASS1", New: "changedPASS1"})
+	if body != problemBody(403, "not_own_password", "You can change only own password") {
+		t.Errorf("change of other password must be forbidden, got %v", body)
+	}
+	body = putWithToken("/api/accounts/"+acc.ID.Hex()+"/password", token, ChangePasswordData{Old: "wrongPASS1", New: "changedPASS1"}, "If-Match", accountETag(acc))
+	if body != problemBody(401, "bad_old_password", "Bad old password") {
+		t.Errorf("bad old password must be rejected, got %v", body)
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"regexp"
+)
+
+const (
+	PermAccountsRead   = "accounts:read"
+	PermAccountsWrite  = "accounts:write"
+	PermPolicyWrite    = "policy:write"
+	PermSessionsRead   = "sessions:read"
+	PermSessionsRevoke = "sessions:revoke"
+	PermRolesRead      = "roles:read"
+	PermRolesWrite     = "roles:write"
+	PermAuditRead      = "audit:read"
+)
+
+var Permissions = []string{
+	PermAccountsRead,
+	PermAccountsWrite,
+	PermPolicyWrite,
+	PermSessionsRead,
+	PermSessionsRevoke,
+	PermRolesRead,
+	PermRolesWrite,
+	PermAuditRead,
+}
+
+var ErrRoleNotFound = NewError(KindNotFound, "role_not_found", "Role not found")
+
+var roleNameRe = regexp.MustCompile("^[a-z0-9_-]{1,64}$")
+
+// Role is named set of permissions. Accounts get permissions of all their roles,
+// supervisor has every permission without roles.
+type Role struct {
+	Name        string   `json:"name" bson:"name"`
+	Permissions []string `json:"permissions" bson:"permissions"`
+}
+
+func isPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks role name and that every permission of role is known.
+func (r *Role) Validate() error {
+	if !roleNameRe.MatchString(r.Name) {
+		return NewError(KindValidation, "bad_role", "Bad role name %q", r.Name)
+	}
+	for _, p := range r.Permissions {
+		if !isPermission(p) {
+			return NewError(KindValidation, "bad_role", "Unknown permission %q", p)
+		}
+	}
+	return nil
+}
+
+func (r *Role) Has(permission string) bool {
+	for _, p := range r.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports that account has permission through one of its roles.
+// Roles which were deleted are skipped.
+func (a *AuthManager) HasPermission(ctx context.Context, acc *Account, permission string) (bool, error) {
+	if a.config.IsSupervisor(acc) {
+		return true, nil
+	}
+	for _, name := range acc.Roles {
+		role, err := a.rolesStorage.GetRole(ctx, name)
+		if err == ErrRoleNotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if role.Has(permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Covers reports that actor has every permission of account, so taking over account
+// does not give actor more rights. Only supervisor covers supervisor.
+func (a *AuthManager) Covers(ctx context.Context, actor, acc *Account) (bool, error) {
+	if a.config.IsSupervisor(acc) {
+		return a.config.IsSupervisor(actor), nil
+	}
+	for _, permission := range Permissions {
+		needed, err := a.HasPermission(ctx, acc, permission)
+		if err != nil {
+			return false, err
+		}
+		if !needed {
+			continue
+		}
+		has, err := a.HasPermission(ctx, actor, permission)
+		if err != nil || !has {
+			return false, err
+		}
+	}
+	return true, nil
+}
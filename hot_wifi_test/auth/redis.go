@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisSessionsStorage keeps every session as key with native expiry. Expiry is sliding:
+// touching session prolongs it for ttl. Ids of sessions of login are kept in set for
+// listing and bulk revocation.
+type RedisSessionsStorage struct {
+	client *redis.Client
+	ttl    time.Duration
+	prefix string
+}
+
+var _ SessionsStorage = (*RedisSessionsStorage)(nil)
+
+func NewRedisSessionStorage(client *redis.Client, ttl time.Duration) *RedisSessionsStorage {
+	return &RedisSessionsStorage{client: client, ttl: ttl, prefix: "hot_wifi:"}
+}
+
+func InitRedis(config *RedisConfig) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{Addr: config.Addr, Password: config.Password, DB: config.DB})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (st *RedisSessionsStorage) Ping(ctx context.Context) error {
+	return st.client.Ping(ctx).Err()
+}
+
+func (st *RedisSessionsStorage) sessionKey(id string) string {
+	return st.prefix + "session:" + id
+}
+
+func (st *RedisSessionsStorage) loginKey(login string) string {
+	return st.prefix + "login:" + login
+}
+
+func (st *RedisSessionsStorage) SetSession(ctx context.Context, session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	_, err = st.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, st.sessionKey(session.ID), data, st.ttl)
+		p.SAdd(ctx, st.loginKey(session.Login), session.ID)
+		p.Expire(ctx, st.loginKey(session.Login), st.ttl)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error at set session : %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *RedisSessionsStorage) GetSession(ctx context.Context, id string) (*Session, error) {
+	data, err := st.client.Get(ctx, st.sessionKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error at get session: %s", err)
+		return nil, err
+	}
+	s := Session{}
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		log.Printf("Error at decode session: %s", err)
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (st *RedisSessionsStorage) GetSessions(ctx context.Context, login string) ([]Session, error) {
+	ids, err := st.client.SMembers(ctx, st.loginKey(login)).Result()
+	if err != nil {
+		log.Printf("Error at get sessions: %s", err)
+		return nil, err
+	}
+	result := []Session{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = st.sessionKey(id)
+	}
+	values, err := st.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Printf("Error at get sessions: %s", err)
+		return nil, err
+	}
+	expired := []interface{}{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+		s := Session{}
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			log.Printf("Error at decode session: %s", err)
+			continue
+		}
+		result = append(result, s)
+	}
+	if len(expired) > 0 {
+		st.client.SRem(ctx, st.loginKey(login), expired...)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+	return result, nil
+}
+
+// CountSessions counts keys of sessions, expired keys are removed by redis.
+func (st *RedisSessionsStorage) CountSessions(ctx context.Context) (int, error) {
+	count := 0
+	iter := st.client.Scan(ctx, 0, st.sessionKey("*"), 1000).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("Error at count sessions: %s", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// TouchSession updates last seen time and prolongs session for ttl.
+func (st *RedisSessionsStorage) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+	s, err := st.GetSession(ctx, id)
+	if err != nil || s == nil {
+		return err
+	}
+	s.LastSeen = lastSeen
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = st.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.SetXX(ctx, st.sessionKey(id), data, st.ttl)
+		p.Expire(ctx, st.loginKey(s.Login), st.ttl)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error at touch session : %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *RedisSessionsStorage) DeleteSession(ctx context.Context, id string) error {
+	s, err := st.GetSession(ctx, id)
+	if err != nil || s == nil {
+		return err
+	}
+	_, err = st.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, st.sessionKey(id))
+		p.SRem(ctx, st.loginKey(s.Login), id)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error at deleting session %s", err)
+		return err
+	}
+	return nil
+}
+
+func (st *RedisSessionsStorage) DeleteSessions(ctx context.Context, login string) error {
+	ids, err := st.client.SMembers(ctx, st.loginKey(login)).Result()
+	if err != nil {
+		log.Printf("Error at deleting sessions %s", err)
+		return err
+	}
+	keys := []string{st.loginKey(login)}
+	for _, id := range ids {
+		keys = append(keys, st.sessionKey(id))
+	}
+	err = st.client.Del(ctx, keys...).Err()
+	if err != nil {
+		log.Printf("Error at deleting sessions %s", err)
+		return err
+	}
+	return nil
+}
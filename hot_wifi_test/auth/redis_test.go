@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisStorage(t *testing.T) (*RedisSessionsStorage, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisSessionStorage(client, time.Minute), mr
+}
+
+func TestRedisSessions(t *testing.T) {
+	ctx := context.Background()
+	st, _ := newTestRedisStorage(t)
+	now := time.Now().Round(time.Second)
+	st.SetSession(ctx, &Session{ID: "first", Login: "user", Created: now, UserAgent: "phone", Token: "secret"})
+	st.SetSession(ctx, &Session{ID: "second", Login: "user", Created: now.Add(time.Second)})
+	st.SetSession(ctx, &Session{ID: "other", Login: "other", Created: now})
+
+	s, err := st.GetSession(ctx, "first")
+	if err != nil || s == nil || s.Login != "user" || s.UserAgent != "phone" || !s.Created.Equal(now) {
+		t.Fatalf("session must be found, got %v %v", s, err)
+	}
+	if s.Token != "" {
+		t.Errorf("access token must not be stored")
+	}
+
+	sessions, _ := st.GetSessions(ctx, "user")
+	if len(sessions) != 2 || sessions[0].ID != "first" || sessions[1].ID != "second" {
+		t.Fatalf("both sessions must be listed, got %v", sessions)
+	}
+	if count, err := st.CountSessions(ctx); count != 3 || err != nil {
+		t.Errorf("sessions of all logins must be counted, got %d %v", count, err)
+	}
+	if err := st.Ping(context.Background()); err != nil {
+		t.Errorf("redis must answer ping: %s", err)
+	}
+
+	st.DeleteSession(ctx, "first")
+	if s, _ := st.GetSession(ctx, "first"); s != nil {
+		t.Errorf("session must be deleted")
+	}
+	if sessions, _ := st.GetSessions(ctx, "user"); len(sessions) != 1 {
+		t.Errorf("other session of login must be kept, got %v", sessions)
+	}
+
+	st.DeleteSessions(ctx, "user")
+	if s, _ := st.GetSession(ctx, "second"); s != nil {
+		t.Errorf("all sessions of login must be deleted")
+	}
+	if s, _ := st.GetSession(ctx, "other"); s == nil {
+		t.Errorf("session of other login must be kept")
+	}
+}
+
+func TestRedisSessionsSlidingExpiry(t *testing.T) {
+	ctx := context.Background()
+	st, mr := newTestRedisStorage(t)
+	st.SetSession(ctx, &Session{ID: "sliding", Login: "user"})
+	st.SetSession(ctx, &Session{ID: "idle", Login: "user"})
+
+	mr.FastForward(50 * time.Second)
+	seen := time.Now()
+	st.TouchSession(ctx, "sliding", seen)
+	mr.FastForward(50 * time.Second)
+
+	if s, _ := st.GetSession(ctx, "idle"); s != nil {
+		t.Errorf("idle session must be expired")
+	}
+	s, _ := st.GetSession(ctx, "sliding")
+	if s == nil {
+		t.Fatalf("touched session must be prolonged")
+	}
+	if !s.LastSeen.Equal(seen) {
+		t.Errorf("last seen must be updated, got %v", s.LastSeen)
+	}
+	if sessions, _ := st.GetSessions(ctx, "user"); len(sessions) != 1 {
+		t.Errorf("expired session must not be listed, got %v", sessions)
+	}
+	if members, _ := mr.SMembers(st.loginKey("user")); len(members) != 1 {
+		t.Errorf("expired session must be removed from login set, got %v", members)
+	}
+
+	mr.FastForward(time.Minute)
+	if s, _ := st.GetSession(ctx, "sliding"); s != nil {
+		t.Errorf("session must be expired after ttl without touches")
+	}
+	if mr.Exists(st.loginKey("user")) {
+		t.Errorf("login set must expire with sessions")
+	}
+}
+
+func TestRedisTouchOfDeletedSession(t *testing.T) {
+	ctx := context.Background()
+	st, _ := newTestRedisStorage(t)
+	st.SetSession(ctx, &Session{ID: "deleted", Login: "user"})
+	st.DeleteSession(ctx, "deleted")
+	st.TouchSession(ctx, "deleted", time.Now())
+	if s, _ := st.GetSession(ctx, "deleted"); s != nil {
+		t.Errorf("touch must not restore deleted session")
+	}
+}
+
+func TestRedisCancelledContext(t *testing.T) {
+	st, _ := newTestRedisStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := st.SetSession(ctx, &Session{ID: "cancelled", Login: "user"}); err != context.Canceled {
+		t.Errorf("cancelled operation must fail, got %v", err)
+	}
+	if s, _ := st.GetSession(context.Background(), "cancelled"); s != nil {
+		t.Errorf("session of cancelled operation must not be stored")
+	}
+}
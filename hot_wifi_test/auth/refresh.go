@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+var ErrInvalidRefreshToken = NewError(KindUnauthenticated, "invalid_refresh_token", "Refresh token is invalid")
+var ErrRefreshTokenReused = NewError(KindUnauthenticated, "refresh_token_reused", "Refresh token was already used, session is revoked")
+
+// RefreshToken is stored refresh token. Only hash of token is kept. Every refresh
+// marks token as used and issues new one of the same family (session), presenting
+// used token again means it was stolen, so whole family is revoked.
+type RefreshToken struct {
+	Hash    string    `bson:"hash"`
+	Family  string    `bson:"family"`
+	Login   string    `bson:"login"`
+	Used    bool      `bson:"used"`
+	Created time.Time `bson:"created"`
+	Expires time.Time `bson:"expires"`
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken makes new token of session family. It expires RefreshTTL after issue,
+// so session which is refreshed in time lives as long as storage keeps it.
+func (a *AuthManager) issueRefreshToken(ctx context.Context, s *Session) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	rt := RefreshToken{
+		Hash:    hashRefreshToken(token),
+		Family:  s.ID,
+		Login:   s.Login,
+		Created: a.now(),
+		Expires: a.now().Add(a.tokens.RefreshTTL),
+	}
+	err = a.refreshTokensStorage.SetRefreshToken(ctx, &rt)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Refresh exchanges refresh token for new access and refresh tokens of the same session.
+func (a *AuthManager) Refresh(ctx context.Context, refreshToken string) (*Session, error) {
+	hash := hashRefreshToken(refreshToken)
+	rt, err := a.refreshTokensStorage.GetRefreshToken(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if rt == nil || !a.now().Before(rt.Expires) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if rt.Used {
+		return nil, a.revokeReused(ctx, rt)
+	}
+
+	sess, err := a.sessionsStorage.GetSession(ctx, rt.Family)
+	if err != nil {
+		return nil, err
+	}
+	if sess == nil || sess.Login != rt.Login {
+		return nil, ErrInvalidRefreshToken
+	}
+	acc, err := a.accountsStorage.GetAccount(ctx, sess.Login)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	fresh, err := a.refreshTokensStorage.UseRefreshToken(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		// concurrent refresh with the same token won
+		return nil, a.revokeReused(ctx, rt)
+	}
+
+	sess.Token, err = a.tokens.Issue(acc, sess.ID)
+	if err != nil {
+		return nil, err
+	}
+	sess.RefreshToken, err = a.issueRefreshToken(ctx, sess)
+	if err != nil {
+		return nil, err
+	}
+	sess.LastSeen = a.now()
+	err = a.sessionsStorage.TouchSession(ctx, sess.ID, sess.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (a *AuthManager) revokeReused(ctx context.Context, rt *RefreshToken) error {
+	log.Printf("Reuse of refresh token of %s detected, session %s is revoked", rt.Login, rt.Family)
+	err := a.revokeSession(ctx, rt.Family)
+	if err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
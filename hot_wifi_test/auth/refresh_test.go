@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func refreshWith(refreshToken string) (*LoginResponse, string) {
+	data, _ := json.Marshal(&RefreshData{RefreshToken: refreshToken})
+	req, _ := http.NewRequest("POST", "/api/accounts/token/refresh", bytes.NewBuffer(data))
+	rr := execResp(req)
+	var resp LoginResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return &resp, rr.Body.String()
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	ctx := context.Background()
+	prepareAccount("refreshed", "refreshedPASS1")
+	first := loginResponseAs("refreshed", "refreshedPASS1")
+	if first.RefreshToken == "" || first.ExpiresIn != cfg.AccessTTL {
+		t.Fatalf("login must return refresh token, got %v", first)
+	}
+
+	second, body := refreshWith(first.RefreshToken)
+	if !second.OK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh must return new tokens, got %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, second.Token); acc == nil || acc.Login != "refreshed" {
+		t.Errorf("refreshed access token must be valid")
+	}
+
+	third, body := refreshWith(second.RefreshToken)
+	if !third.OK {
+		t.Errorf("rotated refresh token must be accepted, got %v", body)
+	}
+	if sessions, _ := ss.GetSessions(ctx, "refreshed"); len(sessions) != 1 {
+		t.Errorf("refresh must keep the same session, got %v", sessions)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	prepareAccount("stolen", "stolenPASS1")
+	first := loginResponseAs("stolen", "stolenPASS1")
+	other := loginResponseAs("stolen", "stolenPASS1")
+	second, _ := refreshWith(first.RefreshToken)
+
+	_, body := refreshWith(first.RefreshToken)
+	if body != problemBody(401, "refresh_token_reused", "Refresh token was already used, session is revoked") {
+		t.Errorf("unexpected body: %v", body)
+	}
+
+	if _, body = refreshWith(second.RefreshToken); body != problemBody(401, "invalid_refresh_token", "Refresh token is invalid") {
+		t.Errorf("tokens of revoked family must be rejected, got %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, second.Token); acc != nil {
+		t.Errorf("access token of revoked family must be rejected")
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, other.Token); acc == nil {
+		t.Errorf("other sessions must be kept")
+	}
+}
+
+func TestRefreshOfRevokedSession(t *testing.T) {
+	ctx := context.Background()
+	prepareAccount("loggedout", "loggedoutPASS1")
+	first := loginResponseAs("loggedout", "loggedoutPASS1")
+	sh.authManager.Logout(ctx, "loggedout")
+
+	if _, body := refreshWith(first.RefreshToken); body != problemBody(401, "invalid_refresh_token", "Refresh token is invalid") {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if _, body := refreshWith("unknown"); body != problemBody(401, "invalid_refresh_token", "Refresh token is invalid") {
+		t.Errorf("unexpected body: %v", body)
+	}
+}
+
+func TestMemoryRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	st := NewMemoryRefreshTokensStorage()
+	st.now = func() time.Time { return now }
+	st.SetRefreshToken(ctx, &RefreshToken{Hash: "a", Family: "f", Expires: now.Add(time.Minute)})
+	st.SetRefreshToken(ctx, &RefreshToken{Hash: "b", Family: "f", Expires: now.Add(time.Minute)})
+
+	if ok, _ := st.UseRefreshToken(ctx, "a"); !ok {
+		t.Errorf("token must be used first time")
+	}
+	if ok, _ := st.UseRefreshToken(ctx, "a"); ok {
+		t.Errorf("token must not be used second time")
+	}
+	if rt, _ := st.GetRefreshToken(ctx, "a"); rt == nil || !rt.Used {
+		t.Errorf("used token must be kept for reuse detection")
+	}
+
+	st.DeleteRefreshTokens(ctx, "f")
+	if rt, _ := st.GetRefreshToken(ctx, "b"); rt != nil {
+		t.Errorf("tokens of family must be deleted")
+	}
+
+	st.SetRefreshToken(ctx, &RefreshToken{Hash: "c", Family: "g", Expires: now.Add(time.Minute)})
+	now = now.Add(time.Minute)
+	if rt, _ := st.GetRefreshToken(ctx, "c"); rt != nil {
+		t.Errorf("token must be expired")
+	}
+}
+
+func TestRefreshOfTouchedSessionAfterTTL(t *testing.T) {
+	ctx := context.Background()
+	sessions, mr := newTestRedisStorage(t)
+	refreshTokens := NewMemoryRefreshTokensStorage()
+	issuer := *tokens
+	issuer.RefreshTTL = time.Minute
+	manager := NewAuthManager(cfg, sessions, refreshTokens, as, rls, &issuer)
+	now := time.Now()
+	manager.now = func() time.Time { return now }
+	refreshTokens.now = manager.now
+	passTime := func(d time.Duration) {
+		now = now.Add(d)
+		mr.FastForward(d)
+	}
+
+	acc := prepareAccount("sliding", "slidingPASS1")
+	sess, err := manager.Login(ctx, acc, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sess.Created.Equal(now) {
+		t.Errorf("session must be created by clock of manager, got %v", sess.Created)
+	}
+	passTime(40 * time.Second)
+	sess, err = manager.Refresh(ctx, sess.RefreshToken)
+	if err != nil {
+		t.Fatalf("session must be refreshed in time, got %v", err)
+	}
+	passTime(40 * time.Second)
+	if _, err = manager.Refresh(ctx, sess.RefreshToken); err != nil {
+		t.Errorf("touched session must be refreshed after ttl of its login, got %v", err)
+	}
+}
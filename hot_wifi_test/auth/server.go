@@ -1,34 +1,38 @@
 package auth
 
 import (
-	"net/http"
-	"github.com/gorilla/mux"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"time"
 )
 
 type ServerHandler struct {
-	accountsStorage *AccountsStorage
+	config          *Config
+	hashing         *PasswordHashing
+	accountsStorage AccountsStorage
 	authManager     *AuthManager
-	policyStorage   *PolicyStorage
+	policyStorage   PolicyStorage
+	rolesStorage    RolesStorage
+	lockoutsStorage LockoutsStorage
+	auditStorage    AuditStorage
+	providers       []IdentityProvider
+	metrics         *Metrics
+	// readiness are backends checked by /readyz
+	readiness []Pinger
 }
 
 func (sh *ServerHandler) getAccounts(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	accounts, err := sh.accountsStorage.GetAccountsViews()
+	accounts, err := sh.accountsStorage.GetAccountsViews(r.Context())
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
-	result, err := json.Marshal(accounts)
-	if err != nil {
-		WriteError(w, err, 500)
-		return
-	}
-	w.Write(result)
-	w.WriteHeader(200)
-
+	WriteOK(w, accounts)
 }
 
 type AccountCreateResponse struct {
@@ -36,106 +40,288 @@ type AccountCreateResponse struct {
 	Id string `json:"id"`
 }
 
-func (sh *ServerHandler) createAccount(w http.ResponseWriter, r *http.Request) {
-	data, err := ReadBody(r)
+const maxIdempotencyKeyLength = 255
+
+var ErrBadIdempotencyKey = NewError(KindValidation, "bad_idempotency_key", "Idempotency-Key must be not longer than 255 bytes")
+
+// hashRequest returns HMAC-SHA256 of request keyed by random salt as "salt:mac".
+func hashRequest(request []byte) (string, error) {
+	salt, err := randomBytes(16)
 	if err != nil {
-		WriteError(w, err, 500)
-		return
+		return "", err
 	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(request)
+	return hex.EncodeToString(salt) + ":" + hex.EncodeToString(mac.Sum(nil)), nil
+}
 
-	var account Account
-	err = json.Unmarshal(data, &account)
+// isSameRequest reports that request has hash made by hashRequest.
+func isSameRequest(request []byte, hash string) bool {
+	parts := strings.SplitN(hash, ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[0])
 	if err != nil {
-		WriteError(w, err, 500)
-		return
+		return false
+	}
+	sum, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
 	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(request)
+	return hmac.Equal(mac.Sum(nil), sum)
+}
 
-	policy, err := sh.policyStorage.GetPolicy()
+// createAccount only inserts new account, taken login is conflict. Request with
+// Idempotency-Key header which repeats successful one answers with the same account,
+// other request with the same key is conflict.
+func (sh *ServerHandler) createAccount(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKeyLength {
+		WriteError(w, ErrBadIdempotencyKey)
+		return
+	}
+	var accountData AccountCreateData
+	err := decodeJSON(w, r, &accountData)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
+	accountData.Login = NormalizeLogin(accountData.Login)
 
-	if !policy.CheckPassword(account.Password) {
-		WriteError(w, errors.New("Password is invalid"), 401)
+	auditEvent(r).Target = accountData.Login
+	if accountData.Login == NormalizeLogin(sh.config.Supervisor.Login) {
+		WriteError(w, ErrLoginAlreadyExists)
+		return
+	}
+	account := Account{Login: accountData.Login, IsExternalAccount: accountData.IsExternalAccount, IdempotencyKey: key}
+	request, err := json.Marshal(&accountData)
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
-	account.SetNewPassword(account.Password)
-	id, err := sh.accountsStorage.SetAccount(&account)
+	if key != "" {
+		account.IdempotencyHash, err = hashRequest(request)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	// external accounts are authenticated by identity providers and have no local password
+	if !account.IsExternalAccount {
+		policy, err := sh.policyStorage.GetPolicy(r.Context())
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		if violations := policy.CheckAccountPassword(sh.hashing, &account, accountData.Password); len(violations) > 0 {
+			WriteError(w, ErrPasswordInvalid.WithViolations(violations))
+			return
+		}
+		err = account.SetNewPassword(sh.hashing, accountData.Password)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	err = sh.accountsStorage.CreateAccount(r.Context(), &account)
+	if err == ErrLoginAlreadyExists && key != "" {
+		existing, getErr := sh.accountsStorage.GetAccount(r.Context(), account.Login)
+		if getErr != nil {
+			WriteError(w, getErr)
+			return
+		}
+		if existing != nil && existing.IdempotencyKey == key {
+			if !isSameRequest(request, existing.IdempotencyHash) {
+				WriteError(w, ErrIdempotencyKeyReused)
+				return
+			}
+			WriteOK(w, AccountCreateResponse{Id: existing.ID.Hex(), OK: true})
+			return
+		}
+	}
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
+		return
 	}
-	objId := id.(primitive.ObjectID)
-	WriteOK(w, AccountCreateResponse{Id: objId.Hex(), OK: true})
+	WriteOK(w, AccountCreateResponse{Id: account.ID.Hex(), OK: true})
 }
 
+var ErrNotOwnPassword = NewError(KindForbidden, "not_own_password", "You can change only own password")
+var ErrNotOwnAccount = NewError(KindForbidden, "not_own_account", "You can delete only own account")
+var ErrBadOldPassword = NewError(KindUnauthenticated, "bad_old_password", "Bad old password")
+
 type ChangePasswordData struct {
 	Old string `json:"oldPassword"`
 	New string `json:"newPassword"`
 }
 
+func (d *ChangePasswordData) validateFields() []FieldError {
+	fe := fieldErrors{}
+	fe.password("oldPassword", d.Old)
+	fe.password("newPassword", d.New)
+	return fe
+}
+
 func (sh *ServerHandler) changePassword(w http.ResponseWriter, r *http.Request, ownerAcc *Account) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	auditEvent(r).Target = ownerAcc.Login
 	if ownerAcc.ID.Hex() != id {
-		WriteError(w, errors.New("You can change only own password"), 500)
+		WriteError(w, ErrNotOwnPassword)
 		return
 	}
-	acc, err := sh.accountsStorage.GetAccountById(id)
+	acc, err := sh.accountsStorage.GetAccountById(r.Context(), id)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
 
-	data, err := ReadBody(r)
+	var cp ChangePasswordData
+	err = decodeJSON(w, r, &cp)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
-	var cp ChangePasswordData
-	err = json.Unmarshal(data, &cp)
-	if err != nil {
-		WriteError(w, err, 500)
+	// owner gets ETag of own account at GET /api/accounts/sessions
+	if !ifMatch(w, r, acc.Version) {
 		return
 	}
 
-	if acc.PasswordHash == CreateHash(cp.Old) {
-		policy, err := sh.policyStorage.GetPolicy()
+	ok, _, err := acc.CheckPassword(sh.hashing, cp.Old)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if ok {
+		policy, err := sh.policyStorage.GetPolicy(r.Context())
 		if err != nil {
-			WriteError(w, err, 500)
+			WriteError(w, err)
 			return
 		}
-		if !policy.CheckPassword(cp.New) {
-			WriteError(w, errors.New("New password is invalid"), 401)
+		violations := append(policy.CheckPasswordAge(acc), policy.CheckAccountPassword(sh.hashing, acc, cp.New)...)
+		if len(violations) > 0 {
+			WriteError(w, ErrNewPasswordInvalid.WithViolations(violations))
 			return
 		}
-		acc.SetNewPassword(cp.New)
-		_, err = sh.accountsStorage.SetAccount(acc)
+		err = acc.SetNewPassword(sh.hashing, cp.New)
 		if err != nil {
-			WriteError(w, err, 500)
+			WriteError(w, err)
+			return
+		}
+		err = sh.accountsStorage.UpdateAccount(r.Context(), acc)
+		if err != nil {
+			WriteError(w, err)
 		} else {
+			setETag(w, acc.Version)
 			WriteOK(w, OkResponse{OK: true})
 		}
 	} else {
-		WriteError(w, errors.New("Bad old password"), 401)
+		WriteError(w, ErrBadOldPassword)
 	}
 }
+
+var ErrPasswordExpired = NewError(KindForbidden, "password_expired", "Password is expired, change it at /api/accounts/password/change-expired")
+var ErrPasswordNotExpired = NewError(KindValidation, "password_not_expired", "Password is not expired, change it at /api/accounts/{id}/password")
+var ErrPasswordNotChanged = NewError(KindValidation, "password_not_changed", "New password must differ from expired one")
+
+// isPasswordExpired checks account password by policy, password of supervisor never expires.
+func (sh *ServerHandler) isPasswordExpired(acc *Account, policy *PasswordPolicy) bool {
+	return !sh.config.IsSupervisor(acc) && acc.IsPasswordExpired(policy.PasswordMaxAge(sh.config.PasswordTTL))
+}
+
+type ExpiredPasswordChangeData struct {
+	Login string `json:"login"`
+	Old   string `json:"oldPassword"`
+	New   string `json:"newPassword"`
+}
+
+func (d *ExpiredPasswordChangeData) validateFields() []FieldError {
+	fe := fieldErrors{}
+	fe.check(d.Login != "" && len(d.Login) <= maxLoginLength, "login", "must not be empty and longer than 128 bytes")
+	fe.password("oldPassword", d.Old)
+	fe.password("newPassword", d.New)
+	return fe
+}
+
+// changeExpiredPassword changes expired password without session, account is
+// authenticated by login and old password.
+func (sh *ServerHandler) changeExpiredPassword(w http.ResponseWriter, r *http.Request) {
+	var cp ExpiredPasswordChangeData
+	err := decodeJSON(w, r, &cp)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	auditEvent(r).Actor = cp.Login
+	acc := sh.authenticate(r.Context(), w, cp.Login, cp.Old)
+	if acc == nil {
+		return
+	}
+	policy, err := sh.policyStorage.GetPolicy(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if !sh.isPasswordExpired(acc, policy) {
+		WriteError(w, ErrPasswordNotExpired)
+		return
+	}
+	if cp.New == cp.Old {
+		WriteError(w, ErrPasswordNotChanged)
+		return
+	}
+	if violations := policy.CheckAccountPassword(sh.hashing, acc, cp.New); len(violations) > 0 {
+		WriteError(w, ErrNewPasswordInvalid.WithViolations(violations))
+		return
+	}
+	err = acc.SetNewPassword(sh.hashing, cp.New)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	err = sh.accountsStorage.UpdateAccount(r.Context(), acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
+
+// deleteAccount deletes own account, accounts with accounts:write permission may
+// delete any account except supervisor one.
 func (sh *ServerHandler) deleteAccount(w http.ResponseWriter, r *http.Request, acc *Account) {
 	vars := mux.Vars(r)
 	id := vars["id"]
+	auditEvent(r).Target = id
 	if acc.ID.Hex() != id {
-		WriteError(w, errors.New("You can delete only own account"), 401)
-		return
+		allowed, err := sh.authManager.HasPermission(r.Context(), acc, PermAccountsWrite)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		if !allowed {
+			WriteError(w, ErrNotOwnAccount)
+			return
+		}
+		acc = sh.adminAccountFromVars(w, r)
+		if acc == nil {
+			return
+		}
 	}
-	err := sh.accountsStorage.DeleteAccount(id)
+	auditEvent(r).Target = acc.Login
+	err := sh.accountsStorage.DeleteAccount(r.Context(), id)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
-	err = sh.authManager.Logout(acc.Login)
+	err = sh.authManager.Logout(r.Context(), acc.Login)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
 	WriteOK(w, OkResponse{OK: true})
@@ -146,96 +332,202 @@ type LoginData struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
 }
+
+// validateFields does not check characters of login, accounts made before validation may have any.
+func (d *LoginData) validateFields() []FieldError {
+	fe := fieldErrors{}
+	fe.check(d.Login != "" && len(d.Login) <= maxLoginLength, "login", "must not be empty and longer than 128 bytes")
+	fe.password("password", d.Password)
+	return fe
+}
+
 type LoginResponse struct {
-	OK    bool   `json:"ok"`
-	Token string `json:"auth-token"`
+	OK           bool   `json:"ok"`
+	Token        string `json:"auth-token"`
+	RefreshToken string `json:"refresh-token"`
+	ExpiresIn    int    `json:"expires-in"`
+	// RecoveryCodes are returned once when TOTP is enrolled at login.
+	RecoveryCodes []string `json:"recovery-codes,omitempty"`
 }
 
-func (sh *ServerHandler) login(w http.ResponseWriter, r *http.Request) {
-	data, err := ReadBody(r)
-	if err != nil {
-		WriteError(w, err, 500)
-		return
+func (sh *ServerHandler) loginResponse(sess *Session) *LoginResponse {
+	return &LoginResponse{
+		OK:           true,
+		Token:        sess.Token,
+		RefreshToken: sess.RefreshToken,
+		ExpiresIn:    int(sh.authManager.tokens.TTL.Seconds()),
 	}
+}
+
+func (sh *ServerHandler) login(w http.ResponseWriter, r *http.Request) {
 	var loginData LoginData
-	err = json.Unmarshal(data, &loginData)
+	err := decodeJSON(w, r, &loginData)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
 
-	acc, err := sh.accountsStorage.GetAccount(loginData.Login)
+	auditEvent(r).Actor = loginData.Login
+	acc := sh.authenticate(r.Context(), w, loginData.Login, loginData.Password)
 	if acc == nil {
-		WriteError(w, errors.New("Can not found account with this login"), 400)
 		return
 	}
-	if !acc.IsPasswordExpire() {
-		WriteError(w, errors.New("Password is expired, change it"), 403)
+	sh.completeLogin(w, r, acc)
+}
+
+// completeLogin starts session of authenticated account unless its password is
+// expired or second factor is needed.
+func (sh *ServerHandler) completeLogin(w http.ResponseWriter, r *http.Request, acc *Account) {
+	policy, err := sh.policyStorage.GetPolicy(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if sh.isPasswordExpired(acc, policy) {
+		WriteError(w, ErrPasswordExpired)
 		return
 	}
-	sess, err := sh.authManager.Login(acc)
+	if acc.TOTPEnabled || policy.Requires2FA(acc, sh.config.IsSupervisor(acc)) {
+		sh.writeMFAChallenge(w, acc)
+		return
+	}
+	sess, err := sh.authManager.Login(r.Context(), acc, r.UserAgent(), ClientIP(r))
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
-	WriteOK(w, &LoginResponse{OK: true, Token: sess.Token})
+	sh.metrics.Login(LoginSuccess)
+	WriteOK(w, sh.loginResponse(sess))
 }
 
-type LogoutData struct {
-	Login string `json:"login"`
+type RefreshData struct {
+	RefreshToken string `json:"refresh-token"`
 }
 
-func (sh *ServerHandler) logout(w http.ResponseWriter, r *http.Request) {
-	data, err := ReadBody(r)
+func (sh *ServerHandler) refresh(w http.ResponseWriter, r *http.Request) {
+	var refreshData RefreshData
+	err := decodeJSON(w, r, &refreshData)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
-	var logoutData LogoutData
-	err = json.Unmarshal(data, &logoutData)
+	sess, err := sh.authManager.Refresh(r.Context(), refreshData.RefreshToken)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
-	err = sh.authManager.Logout(logoutData.Login)
+	auditEvent(r).Actor = sess.Login
+	WriteOK(w, sh.loginResponse(sess))
+}
+
+// logout revokes only session of presented token, other sessions of account are kept.
+func (sh *ServerHandler) logout(w http.ResponseWriter, r *http.Request, acc *Account, sess *Session) {
+	auditEvent(r).Target = acc.Login
+	_, err := sh.authManager.Revoke(r.Context(), acc.Login, sess.ID)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
+		return
 	}
 	WriteOK(w, &OkResponse{OK: true})
 }
 
+// setPolicy replaces policy if If-Match has ETag of current one.
 func (sh *ServerHandler) setPolicy(w http.ResponseWriter, r *http.Request) {
-	data, err := ReadBody(r)
+	var policyData PasswordPolicy
+	err := decodeJSON(w, r, &policyData)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
-	var policyData PasswordPolicy
-	err = json.Unmarshal(data, &policyData)
+	current, err := sh.policyStorage.GetPolicy(r.Context())
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
-	err = sh.policyStorage.SetPolicy(&policyData)
+	if !ifMatch(w, r, current.Version) {
+		return
+	}
+	policyData.Version = current.Version
+	err = sh.policyStorage.SetPolicy(r.Context(), &policyData)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
+		return
 	}
+	setETag(w, policyData.Version)
 	WriteOK(w, &OkResponse{OK: true})
 }
 
-func Router(accountsStorage *AccountsStorage, policyStorage *PolicyStorage, sessionStorage *SessionsStorage) *mux.Router {
-	authManager := AuthManager{sessionsStorage: sessionStorage, accountsStorage: accountsStorage}
-	sh := ServerHandler{accountsStorage: accountsStorage, policyStorage: policyStorage, authManager: &authManager}
-	am := AuthMiddleWare{manager: &authManager}
+func (sh *ServerHandler) jwks(w http.ResponseWriter, r *http.Request) {
+	WriteOK(w, sh.authManager.tokens.Keys.JWKS())
+}
+
+func Router(config *Config, accountsStorage AccountsStorage, policyStorage PolicyStorage, sessionStorage SessionsStorage, refreshTokensStorage RefreshTokensStorage, rolesStorage RolesStorage, lockoutsStorage LockoutsStorage, auditStorage AuditStorage, tokens *TokenIssuer, providers ...IdentityProvider) *mux.Router {
+	readiness := pingers(accountsStorage, sessionStorage)
+	metrics := NewMetrics()
+	timeout := time.Duration(config.StorageTimeout) * time.Second
+	accountsStorage = &instrumentedAccountsStorage{accountsStorage, operations{"accounts", metrics, timeout}}
+	policyStorage = &instrumentedPolicyStorage{policyStorage, operations{"policy", metrics, timeout}}
+	sessionStorage = &instrumentedSessionsStorage{sessionStorage, operations{"sessions", metrics, timeout}}
+	refreshTokensStorage = &instrumentedRefreshTokensStorage{refreshTokensStorage, operations{"refresh_tokens", metrics, timeout}}
+	rolesStorage = &instrumentedRolesStorage{rolesStorage, operations{"roles", metrics, timeout}}
+	lockoutsStorage = &instrumentedLockoutsStorage{lockoutsStorage, operations{"lockouts", metrics, timeout}}
+	auditStorage = &instrumentedAuditStorage{auditStorage, operations{"audit", metrics, timeout}}
+	metrics.WatchSessions(sessionStorage)
+
+	authManager := NewAuthManager(config, sessionStorage, refreshTokensStorage, accountsStorage, rolesStorage, tokens)
+	sh := ServerHandler{config: config, hashing: config.Hashing(), accountsStorage: accountsStorage, policyStorage: policyStorage, rolesStorage: rolesStorage, lockoutsStorage: lockoutsStorage, auditStorage: auditStorage, providers: providers, authManager: authManager, metrics: metrics, readiness: readiness}
+	am := AuthMiddleWare{manager: authManager}
+	loginLimiter := NewRateLimiter(config.Lockout.RateLimit, time.Duration(config.Lockout.RateWindow)*time.Second)
+	audited := func(action string, next HttpHandlerFunc) HttpHandlerFunc {
+		return Audited(auditStorage, action, next)
+	}
+
+	// forced logout is DELETE /api/accounts/{id}/sessions, POST /api/accounts/{id}/logout is its alias
+	revokeAccountSessions := Json(audited("sessions.revoke", am.RequirePermission(PermSessionsRevoke, sh.revokeAccountSessions)))
 
 	r := mux.NewRouter()
-	r.HandleFunc("/accounts", Json(am.MustBeRoot(sh.createAccount))).Methods("POST")
-	r.HandleFunc("/accounts", Json(am.MustBeLoggedIn(sh.getAccounts))).Methods("GET")
-	r.HandleFunc("/api/accounts/{id}", Json(am.MustChangeYourth(sh.deleteAccount))).Methods("DELETE")
-	r.HandleFunc("/api/accounts/{id}/password", Json(am.MustChangeYourth(sh.changePassword))).Methods("PUT")
-	r.HandleFunc("/api/accounts/login", Json(sh.login)).Methods("POST")
-	r.HandleFunc("/api/accounts/logout", Json(am.MustBeLoggedIn(sh.logout))).Methods("POST")
-	r.HandleFunc("/api/accounts/password/policy", Json(am.MustBeRoot(sh.setPolicy))).Methods("POST")
+	r.Use(metrics.Middleware)
+	r.HandleFunc("/healthz", Json(sh.healthz)).Methods("GET")
+	r.HandleFunc("/readyz", Json(sh.readyz)).Methods("GET")
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+	r.HandleFunc("/accounts", Json(audited("account.create", am.RequirePermission(PermAccountsWrite, sh.createAccount)))).Methods("POST")
+	r.HandleFunc("/accounts", Json(audited("account.list", am.RequirePermission(PermAccountsRead, sh.getAccounts)))).Methods("GET")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}", Json(audited("account.delete", am.MustChangeYourth(sh.deleteAccount)))).Methods("DELETE")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}", Json(audited("account.read", am.RequirePermission(PermAccountsRead, sh.getAccount)))).Methods("GET")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}", Json(audited("account.update", am.RequirePermissionWithAcc(PermAccountsWrite, sh.updateAccount)))).Methods("PATCH")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}/password", Json(audited("password.change", am.MustChangeYourth(sh.changePassword)))).Methods("PUT")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}/password/reset", Json(audited("password.reset", am.RequirePermissionWithAcc(PermAccountsWrite, sh.resetPassword)))).Methods("POST")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}/roles", Json(audited("account.roles", am.RequirePermission(PermRolesWrite, sh.setAccountRoles)))).Methods("PUT")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}/sessions", Json(audited("sessions.list", am.RequirePermission(PermSessionsRead, sh.getAccountSessions)))).Methods("GET")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}/sessions", revokeAccountSessions).Methods("DELETE")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}/logout", revokeAccountSessions).Methods("POST")
+	r.HandleFunc("/api/accounts/{id:[0-9a-f]{24}}/sessions/{sid}", Json(audited("session.revoke", am.RequirePermission(PermSessionsRevoke, sh.revokeAccountSession)))).Methods("DELETE")
+	r.HandleFunc("/api/accounts/lockouts", Json(audited("lockouts.list", am.RequirePermission(PermAccountsRead, sh.getLockouts)))).Methods("GET")
+	r.HandleFunc("/api/accounts/lockouts/{login}", Json(audited("lockout.clear", am.RequirePermission(PermAccountsWrite, sh.clearLockout)))).Methods("DELETE")
+	r.HandleFunc("/api/accounts/sessions", Json(audited("sessions.list", am.MustHaveSession(sh.getSessions)))).Methods("GET")
+	r.HandleFunc("/api/accounts/sessions", Json(audited("sessions.revoke", am.MustHaveSession(sh.revokeSessions)))).Methods("DELETE")
+	r.HandleFunc("/api/accounts/sessions/{sid}", Json(audited("session.revoke", am.MustHaveSession(sh.revokeSession)))).Methods("DELETE")
+	r.HandleFunc("/api/accounts/login", Json(audited("login", RateLimited(loginLimiter, sh.login)))).Methods("POST")
+	r.HandleFunc("/api/accounts/login/mfa", Json(audited("login.mfa", RateLimited(loginLimiter, sh.loginMFA)))).Methods("POST")
+	r.HandleFunc("/api/accounts/login/mfa/enrol", Json(audited("login.mfa_enrol", RateLimited(loginLimiter, sh.loginMFAEnrol)))).Methods("POST")
+	r.HandleFunc("/api/accounts/2fa/enrol", Json(audited("2fa.enrol", am.MustChangeYourth(sh.enrolTOTP)))).Methods("POST")
+	r.HandleFunc("/api/accounts/2fa/confirm", Json(audited("2fa.confirm", am.MustChangeYourth(sh.confirmTOTP)))).Methods("POST")
+	r.HandleFunc("/api/accounts/2fa/recovery-codes", Json(audited("2fa.recovery_codes", am.MustChangeYourth(sh.regenerateRecoveryCodes)))).Methods("POST")
+	r.HandleFunc("/api/accounts/2fa", Json(audited("2fa.disable", am.MustChangeYourth(sh.disableTOTP)))).Methods("DELETE")
+	r.HandleFunc("/api/accounts/oidc/{provider}/login", audited("login.oidc_start", sh.oidcLogin)).Methods("GET")
+	r.HandleFunc("/api/accounts/oidc/{provider}/callback", Json(audited("login.oidc", sh.oidcCallback))).Methods("GET")
+	r.HandleFunc("/api/accounts/token/refresh", Json(audited("token.refresh", sh.refresh))).Methods("POST")
+	r.HandleFunc("/api/accounts/logout", Json(audited("logout", am.MustHaveSession(sh.logout)))).Methods("POST")
+	r.HandleFunc("/api/accounts/logout/all", Json(audited("logout.all", am.MustHaveSession(sh.revokeSessions)))).Methods("POST")
+	r.HandleFunc("/api/accounts/password/change-expired", Json(audited("password.change_expired", RateLimited(loginLimiter, sh.changeExpiredPassword)))).Methods("POST")
+	r.HandleFunc("/api/accounts/password/policy", Json(audited("policy.update", am.RequirePermission(PermPolicyWrite, sh.setPolicy)))).Methods("POST")
+	r.HandleFunc("/api/accounts/password/policy", Json(audited("policy.read", sh.getPolicy))).Methods("GET")
+	r.HandleFunc("/api/accounts/password/check", Json(audited("password.check", RateLimited(loginLimiter, sh.checkPassword)))).Methods("POST")
+	r.HandleFunc("/api/roles", Json(audited("roles.list", am.RequirePermission(PermRolesRead, sh.getRoles)))).Methods("GET")
+	r.HandleFunc("/api/roles/{name:[a-z0-9_-]+}", Json(audited("role.set", am.RequirePermission(PermRolesWrite, sh.setRole)))).Methods("PUT")
+	r.HandleFunc("/api/roles/{name:[a-z0-9_-]+}", Json(audited("role.delete", am.RequirePermission(PermRolesWrite, sh.deleteRole)))).Methods("DELETE")
+	r.HandleFunc("/api/audit", Json(audited("audit.read", am.RequirePermission(PermAuditRead, sh.getAuditEvents)))).Methods("GET")
+	r.HandleFunc("/.well-known/jwks.json", Json(sh.jwks)).Methods("GET")
 
 	return r
 }
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"net/http"
+)
+
+var ErrSupervisorAccount = NewError(KindForbidden, "supervisor_account", "Supervisor account can not be administered")
+var ErrAccountNotCovered = NewError(KindForbidden, "account_not_covered", "Account has permissions which you have not")
+
+// adminAccountFromVars returns account from path for administration, supervisor
+// account is never returned.
+func (sh *ServerHandler) adminAccountFromVars(w http.ResponseWriter, r *http.Request) *Account {
+	acc := sh.accountFromVars(w, r)
+	if acc == nil {
+		return nil
+	}
+	if sh.config.IsSupervisor(acc) {
+		WriteError(w, ErrSupervisorAccount)
+		return nil
+	}
+	return acc
+}
+
+// coveredAccountFromVars returns account from path for change which lets actor take it
+// over, so account must not have permissions which actor has not.
+func (sh *ServerHandler) coveredAccountFromVars(w http.ResponseWriter, r *http.Request, actor *Account) *Account {
+	acc := sh.adminAccountFromVars(w, r)
+	if acc == nil {
+		return nil
+	}
+	covered, err := sh.authManager.Covers(r.Context(), actor, acc)
+	if err != nil {
+		WriteError(w, err)
+		return nil
+	}
+	if !covered {
+		WriteError(w, ErrAccountNotCovered)
+		return nil
+	}
+	return acc
+}
+
+func (sh *ServerHandler) getAccount(w http.ResponseWriter, r *http.Request) {
+	acc := sh.accountFromVars(w, r)
+	if acc == nil {
+		return
+	}
+	setETag(w, acc.Version)
+	WriteOK(w, acc)
+}
+
+// AccountUpdateData is request for changing account, absent fields are kept. External
+// provider and subject link account to identity at provider, they are set together.
+type AccountUpdateData struct {
+	Login             *string `json:"login"`
+	IsExternalAccount *bool   `json:"isExternalAccount"`
+	ExternalProvider  *string `json:"externalProvider"`
+	ExternalSubject   *string `json:"externalSubject"`
+}
+
+func (d *AccountUpdateData) validateFields() []FieldError {
+	fe := fieldErrors{}
+	if d.Login != nil {
+		fe.login("login", *d.Login)
+	}
+	fe.check((d.ExternalProvider == nil) == (d.ExternalSubject == nil), "externalSubject", "must be set together with externalProvider")
+	return fe
+}
+
+// updateAccount changes login and flags of account if If-Match has its ETag. Changed
+// login revokes all sessions of old one. Actor must have every permission of account,
+// because linking it to identity of actor gives account to actor.
+func (sh *ServerHandler) updateAccount(w http.ResponseWriter, r *http.Request, actor *Account) {
+	acc := sh.coveredAccountFromVars(w, r, actor)
+	if acc == nil {
+		return
+	}
+	var update AccountUpdateData
+	err := decodeJSON(w, r, &update)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if !ifMatch(w, r, acc.Version) {
+		return
+	}
+	oldLogin := acc.Login
+	if update.Login != nil {
+		login := NormalizeLogin(*update.Login)
+		if login == NormalizeLogin(sh.config.Supervisor.Login) {
+			WriteError(w, ErrLoginAlreadyExists)
+			return
+		}
+		acc.Login = login
+	}
+	if update.IsExternalAccount != nil {
+		acc.IsExternalAccount = *update.IsExternalAccount
+	}
+	if update.ExternalProvider != nil && update.ExternalSubject != nil {
+		acc.ExternalProvider = *update.ExternalProvider
+		acc.ExternalSubject = *update.ExternalSubject
+	}
+	err = sh.accountsStorage.UpdateAccount(r.Context(), acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if acc.Login != oldLogin {
+		err = sh.authManager.Logout(r.Context(), oldLogin)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	setETag(w, acc.Version)
+	WriteOK(w, acc)
+}
+
+type PasswordResetData struct {
+	Password string `json:"password"`
+}
+
+// resetPassword sets temporary password which must be changed at next login and
+// revokes all sessions of account. If-Match must have ETag of account. Actor must have
+// every permission of account, because actor knows new password.
+func (sh *ServerHandler) resetPassword(w http.ResponseWriter, r *http.Request, actor *Account) {
+	acc := sh.coveredAccountFromVars(w, r, actor)
+	if acc == nil {
+		return
+	}
+	var reset PasswordResetData
+	err := decodeJSON(w, r, &reset)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if !ifMatch(w, r, acc.Version) {
+		return
+	}
+	policy, err := sh.policyStorage.GetPolicy(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if violations := policy.CheckPassword(reset.Password); len(violations) > 0 {
+		WriteError(w, ErrPasswordInvalid.WithViolations(violations))
+		return
+	}
+	err = acc.SetNewPassword(sh.hashing, reset.Password)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	acc.MustChangePassword = true
+	err = sh.accountsStorage.UpdateAccount(r.Context(), acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	err = sh.authManager.Logout(r.Context(), acc.Login)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func requestWithToken(method, url, token string, data interface{}, headers ...string) string {
+	var body *bytes.Buffer
+	if data != nil {
+		encoded, _ := json.Marshal(data)
+		body = bytes.NewBuffer(encoded)
+	} else {
+		body = &bytes.Buffer{}
+	}
+	req, _ := http.NewRequest(method, url, body)
+	req.Header.Set(cfg.HeaderName, token)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	return execResp(req).Body.String()
+}
+
+func TestAdminGetAndUpdateAccount(t *testing.T) {
+	ctx := context.Background()
+	acc := prepareAccount("administered", "administeredPASS1")
+	token := loginAs("administered", "administeredPASS1")
+	url := fmt.Sprintf("/api/accounts/%s", acc.ID.Hex())
+
+	if body := requestWithToken("GET", url, token, nil); body != problemBody(403, "permission_denied", "You have not permission accounts:read") {
+		t.Errorf("unexpected body: %v", body)
+	}
+	var got Account
+	json.Unmarshal([]byte(requestWithToken("GET", url, sToken, nil)), &got)
+	if got.Login != "administered" || got.PasswordHash != "" {
+		t.Errorf("unexpected account: %+v", got)
+	}
+
+	prepareAccount("taken", "takenPASS1")
+	taken := "taken"
+	if body := requestWithToken("PATCH", url, sToken, AccountUpdateData{Login: &taken}, "If-Match", accountETag(acc)); body != problemBody(409, "login_taken", "Account with this login already exists") {
+		t.Errorf("taken login must be rejected, got %v", body)
+	}
+
+	renamed, external := "renamed", true
+	requestWithToken("PATCH", url, sToken, AccountUpdateData{Login: &renamed, IsExternalAccount: &external}, "If-Match", accountETag(acc))
+	stored, _ := as.GetAccountById(ctx, acc.ID.Hex())
+	if stored.Login != "renamed" || !stored.IsExternalAccount {
+		t.Errorf("account must be updated, got %+v", stored)
+	}
+	if old, _ := as.GetAccount(ctx, "administered"); old != nil {
+		t.Errorf("old login must be free")
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, token); acc != nil {
+		t.Errorf("sessions of old login must be revoked")
+	}
+}
+
+func TestAdminResetPassword(t *testing.T) {
+	ctx := context.Background()
+	acc := prepareAccount("forgetful", "forgottenPASS1")
+	token := loginAs("forgetful", "forgottenPASS1")
+	url := fmt.Sprintf("/api/accounts/%s/password/reset", acc.ID.Hex())
+
+	if body := requestWithToken("POST", url, token, PasswordResetData{Password: "temporaryPASS1"}); body != problemBody(403, "permission_denied", "You have not permission accounts:write") {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if body := requestWithToken("POST", url, sToken, PasswordResetData{Password: "temporaryPASS1"}, "If-Match", accountETag(acc)); body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, token); acc != nil {
+		t.Errorf("sessions must be revoked after reset")
+	}
+	if resp := loginResponseAs("forgetful", "temporaryPASS1"); resp.OK {
+		t.Errorf("reset password must be changed before login")
+	}
+	if body := changeExpired("forgetful", "temporaryPASS1", "rememberedPASS1"); body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if token := loginAs("forgetful", "rememberedPASS1"); token == "" {
+		t.Errorf("changed password must allow login")
+	}
+}
+
+func TestAdminCanNotTakeOverStrongerAccount(t *testing.T) {
+	putWithToken("/api/roles/helpdesk", sToken, RoleData{Permissions: []string{PermAccountsWrite}})
+	putWithToken("/api/roles/security", sToken, RoleData{Permissions: []string{PermAccountsWrite, PermRolesWrite, PermAuditRead}})
+	helper := prepareAccount("helper", "helperPASS1")
+	putWithToken(fmt.Sprintf("/api/accounts/%s/roles", helper.ID.Hex()), sToken, AccountRolesData{Roles: []string{"helpdesk"}}, "If-Match", accountETag(helper))
+	admin := prepareAccount("securityadmin", "securityPASS1")
+	putWithToken(fmt.Sprintf("/api/accounts/%s/roles", admin.ID.Hex()), sToken, AccountRolesData{Roles: []string{"security"}}, "If-Match", accountETag(admin))
+	admin, _ = as.GetAccount(context.Background(), "securityadmin")
+	token := loginAs("helper", "helperPASS1")
+
+	notCovered := problemBody(403, "account_not_covered", ErrAccountNotCovered.Message)
+	url := fmt.Sprintf("/api/accounts/%s", admin.ID.Hex())
+	if body := requestWithToken("POST", url+"/password/reset", token, PasswordResetData{Password: "temporaryPASS1"}, "If-Match", accountETag(admin)); body != notCovered {
+		t.Errorf("password of stronger account must not be reset, got %v", body)
+	}
+	provider, subject := "corp", "sub-helper"
+	if body := requestWithToken("PATCH", url, token, AccountUpdateData{ExternalProvider: &provider, ExternalSubject: &subject}, "If-Match", accountETag(admin)); body != notCovered {
+		t.Errorf("stronger account must not be linked, got %v", body)
+	}
+	if token := loginAs("securityadmin", "securityPASS1"); token == "" {
+		t.Errorf("stronger account must keep its password")
+	}
+
+	weak := prepareAccount("helped", "helpedPASS1")
+	url = fmt.Sprintf("/api/accounts/%s/password/reset", weak.ID.Hex())
+	if body := requestWithToken("POST", url, token, PasswordResetData{Password: "temporaryPASS1"}, "If-Match", accountETag(weak)); body != `{"ok":true}` {
+		t.Errorf("password of account without permissions must be reset, got %v", body)
+	}
+}
+
+func TestAdminDeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	acc := prepareAccount("doomed", "doomedPASS1")
+	token := loginAs("doomed", "doomedPASS1")
+	other := prepareAccount("bystander", "bystanderPASS1")
+	otherToken := loginAs("bystander", "bystanderPASS1")
+
+	if body := requestWithToken("DELETE", fmt.Sprintf("/api/accounts/%s", acc.ID.Hex()), otherToken, nil); body != problemBody(403, "not_own_account", "You can delete only own account") {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if body := requestWithToken("DELETE", fmt.Sprintf("/api/accounts/%s", acc.ID.Hex()), sToken, nil); body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if stored, _ := as.GetAccount(ctx, "doomed"); stored != nil {
+		t.Errorf("account must be deleted")
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, token); acc != nil {
+		t.Errorf("sessions of deleted account must be revoked")
+	}
+
+	supervisor, _ := as.GetAccount(ctx, cfg.Supervisor.Login)
+	rls.SetRole(ctx, &Role{Name: "deleters", Permissions: []string{PermAccountsWrite}})
+	other.Roles = []string{"deleters"}
+	as.UpdateAccount(ctx, other)
+	if body := requestWithToken("DELETE", fmt.Sprintf("/api/accounts/%s", supervisor.ID.Hex()), otherToken, nil); body != problemBody(403, "supervisor_account", "Supervisor account can not be administered") {
+		t.Errorf("supervisor must not be deleted, got %v", body)
+	}
+}
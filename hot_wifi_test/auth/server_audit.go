@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 500
+)
+
+type AuditPage struct {
+	OK     bool         `json:"ok"`
+	Events []AuditEvent `json:"events"`
+	Offset int          `json:"offset"`
+	Limit  int          `json:"limit"`
+}
+
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil || result < 0 {
+		return 0, NewError(KindValidation, "bad_query", "Bad %s, must be not negative number", name)
+	}
+	return result, nil
+}
+
+func queryTime(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	result, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, NewError(KindValidation, "bad_query", "Bad %s, must be RFC 3339 time", name)
+	}
+	return result, nil
+}
+
+// getAuditEvents returns page of events filtered by actor, action and time range [from, to).
+func (sh *ServerHandler) getAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter := AuditFilter{Actor: r.URL.Query().Get("actor"), Action: r.URL.Query().Get("action")}
+	var err error
+	if filter.From, err = queryTime(r, "from"); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if filter.To, err = queryTime(r, "to"); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if filter.Offset, err = queryInt(r, "offset", 0); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if filter.Limit, err = queryInt(r, "limit", auditDefaultLimit); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if filter.Limit == 0 || filter.Limit > auditMaxLimit {
+		filter.Limit = auditMaxLimit
+	}
+	events, err := sh.auditStorage.FindEvents(r.Context(), &filter)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, AuditPage{OK: true, Events: events, Offset: filter.Offset, Limit: filter.Limit})
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func getAuditPage(t *testing.T, query url.Values) AuditPage {
+	var page AuditPage
+	body := requestWithToken("GET", "/api/audit?"+query.Encode(), sToken, nil)
+	if err := json.Unmarshal([]byte(body), &page); err != nil || !page.OK {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	return page
+}
+
+func TestAuditLogin(t *testing.T) {
+	prepareAccount("audited", "auditedPASS1")
+	from := time.Now().Add(-time.Second)
+	loginBody("audited", "wrongPASS1")
+	loginBody("audited", "auditedPASS1")
+
+	page := getAuditPage(t, url.Values{"actor": {"audited"}, "action": {"login"}, "from": {from.Format(time.RFC3339)}})
+	if len(page.Events) != 2 {
+		t.Fatalf("both logins must be recorded, got %+v", page.Events)
+	}
+	success, failure := page.Events[0], page.Events[1]
+	if success.Outcome != AuditSuccess || success.Status != 200 || success.IP != "127.0.0.1" {
+		t.Errorf("unexpected success event: %+v", success)
+	}
+	if failure.Outcome != AuditFailure || failure.Status != 401 || failure.Time.After(success.Time) {
+		t.Errorf("unexpected failure event: %+v", failure)
+	}
+}
+
+func TestAuditTarget(t *testing.T) {
+	acc := prepareAccount("audit_target", "auditTargetPASS1")
+	requestWithToken("POST", fmt.Sprintf("/api/accounts/%s/password/reset", acc.ID.Hex()), sToken, PasswordResetData{Password: "auditResetPASS1"}, "If-Match", accountETag(acc))
+
+	page := getAuditPage(t, url.Values{"action": {"password.reset"}, "limit": {"1"}})
+	if len(page.Events) != 1 || page.Events[0].Actor != cfg.Supervisor.Login || page.Events[0].Target != "audit_target" {
+		t.Errorf("reset must be recorded with actor and target, got %+v", page.Events)
+	}
+
+	requestWithToken("DELETE", fmt.Sprintf("/api/accounts/%s", acc.ID.Hex()), sToken, nil)
+	page = getAuditPage(t, url.Values{"action": {"account.delete"}, "limit": {"1"}})
+	if len(page.Events) != 1 || page.Events[0].Actor != cfg.Supervisor.Login || page.Events[0].Target != "audit_target" {
+		t.Errorf("deletion by administrator must be recorded with deleted account as target, got %+v", page.Events)
+	}
+}
+
+func TestAuditPagination(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		loginBody("audit_pages", "wrongPASS1")
+	}
+	first := getAuditPage(t, url.Values{"actor": {"audit_pages"}, "limit": {"2"}})
+	second := getAuditPage(t, url.Values{"actor": {"audit_pages"}, "limit": {"2"}, "offset": {"2"}})
+	if len(first.Events) != 2 || len(second.Events) != 1 || first.Limit != 2 || second.Offset != 2 {
+		t.Errorf("unexpected pages: %+v %+v", first, second)
+	}
+	if empty := getAuditPage(t, url.Values{"actor": {"audit_pages"}, "to": {time.Now().Add(-time.Hour).Format(time.RFC3339)}}); len(empty.Events) != 0 {
+		t.Errorf("time range must filter events, got %+v", empty.Events)
+	}
+}
+
+func TestAuditQueryErrors(t *testing.T) {
+	if body := requestWithToken("GET", "/api/audit?from=yesterday", sToken, nil); body != problemBody(400, "bad_query", "Bad from, must be RFC 3339 time") {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if body := requestWithToken("GET", "/api/audit?limit=-1", sToken, nil); body != problemBody(400, "bad_query", "Bad limit, must be not negative number") {
+		t.Errorf("unexpected body: %v", body)
+	}
+	prepareAccount("audit_reader", "auditReaderPASS1")
+	token := loginAs("audit_reader", "auditReaderPASS1")
+	if body := requestWithToken("GET", "/api/audit", token, nil); body != problemBody(403, "permission_denied", "You have not permission audit:read") {
+		t.Errorf("unexpected body: %v", body)
+	}
+}
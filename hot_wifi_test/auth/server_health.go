@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+var ErrStorageUnreachable = NewError(KindUnavailable, "storage_unreachable", "Storage is not reachable")
+var ErrSupervisorMissing = NewError(KindUnavailable, "supervisor_missing", "Supervisor account is not initialised")
+
+// pingers returns storages which can check their backends.
+func pingers(storages ...interface{}) []Pinger {
+	result := []Pinger{}
+	for _, storage := range storages {
+		if pinger, ok := storage.(Pinger); ok {
+			result = append(result, pinger)
+		}
+	}
+	return result
+}
+
+// healthz answers while process serves requests.
+func (sh *ServerHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	WriteOK(w, OkResponse{OK: true})
+}
+
+// readyz answers ok when storages are reachable and supervisor account exists.
+func (sh *ServerHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	for _, pinger := range sh.readiness {
+		if err := pinger.Ping(ctx); err != nil {
+			log.Printf("Error at ping storage: %s", err)
+			WriteError(w, ErrStorageUnreachable)
+			return
+		}
+	}
+	acc, err := sh.accountsStorage.GetAccount(ctx, sh.config.Supervisor.Login)
+	if err != nil {
+		log.Printf("Error at get supervisor: %s", err)
+		WriteError(w, ErrStorageUnreachable)
+		return
+	}
+	if acc == nil {
+		WriteError(w, ErrSupervisorMissing)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
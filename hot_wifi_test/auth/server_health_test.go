@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type unreachableSessionsStorage struct {
+	*MemorySessionsStorage
+}
+
+func (st *unreachableSessionsStorage) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func getBody(r http.Handler, url string) string {
+	req, _ := http.NewRequest("GET", url, nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr.Body.String()
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	if body := getBody(router, "/healthz"); body != `{"ok":true}` {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if body := getBody(router, "/readyz"); body != `{"ok":true}` {
+		t.Errorf("unexpected body: %v", body)
+	}
+
+	fresh := Router(cfg, NewMemoryAccountsStorage(), ps, ss, rs, rls, ls, aus, tokens)
+	if body := getBody(fresh, "/readyz"); body != problemBody(503, "supervisor_missing", "Supervisor account is not initialised") {
+		t.Errorf("router without supervisor must not be ready, got %v", body)
+	}
+	unreachable := Router(cfg, as, ps, &unreachableSessionsStorage{ss}, rs, rls, ls, aus, tokens)
+	if body := getBody(unreachable, "/readyz"); body != problemBody(503, "storage_unreachable", "Storage is not reachable") {
+		t.Errorf("router with unreachable storage must not be ready, got %v", body)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	prepareAccount("measured", "measuredPASS1")
+	loginAs("measured", "measuredPASS1")
+	loginBody("measured", "wrongPASS1")
+	requestWithToken("GET", "/api/accounts/"+strings.Repeat("0", 24), sToken, nil)
+
+	body := getBody(router, "/metrics")
+	for _, line := range []string{
+		`auth_logins_total{outcome="success"}`,
+		`auth_logins_total{outcome="failure"}`,
+		`auth_http_requests_total{code="200",method="POST",route="/api/accounts/login"}`,
+		`auth_http_requests_total{code="404",method="GET",route="/api/accounts/{id:[0-9a-f]{24}}"}`,
+		`auth_active_sessions`,
+		`auth_storage_operation_duration_seconds_count{operation="GetAccount",storage="accounts"}`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics must contain %s", line)
+		}
+	}
+}
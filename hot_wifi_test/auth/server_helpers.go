@@ -1,11 +1,11 @@
 package auth
 
 import (
-	"net/http"
+	"context"
 	"encoding/json"
-	"fmt"
 	"log"
-	"io/ioutil"
+	"net"
+	"net/http"
 )
 
 type OkResponse struct {
@@ -15,41 +15,20 @@ type OkResponse struct {
 func WriteOK(w http.ResponseWriter, data interface{}) {
 	res, err := json.Marshal(data)
 	if err != nil {
-		WriteError(w, err, 500)
+		WriteError(w, err)
 		return
 	}
-	w.Write(res)
 	w.WriteHeader(200)
-}
-
-type ErrorResponse struct {
-	OK    bool   `json:"ok"`
-	Error string `json:"error"`
-}
-
-func WriteError(w http.ResponseWriter, err error, statusCode int) {
-	res, err := json.Marshal(&ErrorResponse{Error: err.Error()})
-	if err != nil {
-		log.Printf(fmt.Sprintf("Can not marshall error: %s", err))
-		w.WriteHeader(500)
-		return
-	}
 	w.Write(res)
-	w.WriteHeader(statusCode)
 }
 
-func ReadBody(r *http.Request) ([]byte, error) {
-	bodyReader, err := r.GetBody()
-	if err != nil {
-		log.Printf("Error at get body from request %v", err)
-		return nil, err
-	}
-	data, err := ioutil.ReadAll(bodyReader)
+// ClientIP returns address of connected client without port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		log.Printf("Error at read body from request %v", err)
-		return nil, err
+		return r.RemoteAddr
 	}
-	return data, err
+	return host
 }
 
 func Json(next HttpHandlerFunc) HttpHandlerFunc {
@@ -59,15 +38,21 @@ func Json(next HttpHandlerFunc) HttpHandlerFunc {
 	}
 }
 
-func PrepareSupervisor(as *AccountsStorage) *Account {
-	acc, err := as.GetAccount(SUPERVISOR_LOGIN)
+func PrepareSupervisor(ctx context.Context, config *Config, as AccountsStorage) *Account {
+	acc, err := as.GetAccount(ctx, config.Supervisor.Login)
 	if err != nil {
 		panic(err)
 	}
 	if acc == nil {
-		acc = &Account{Login: SUPERVISOR_LOGIN}
-		acc.SetNewPassword(SUPERVISOR_PASSWORD)
-		as.SetAccount(acc)
+		acc = &Account{Login: config.Supervisor.Login}
+		err = acc.SetNewPassword(config.Hashing(), config.Supervisor.Password)
+		if err != nil {
+			panic(err)
+		}
+		err = as.CreateAccount(ctx, acc)
+		if err != nil {
+			panic(err)
+		}
 		log.Println("Supervisor initialised")
 	}
 
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrUnknownProvider = NewError(KindNotFound, "unknown_provider", "Unknown identity provider")
+var ErrProviderRefused = NewError(KindUnauthenticated, "provider_refused", "Identity provider did not confirm login")
+var ErrBadOIDCState = NewError(KindValidation, "bad_oidc_state", "Login at identity provider is expired or forged, start again")
+
+// oidcFlow is kept in cookie between redirect to provider and callback, so callback
+// may be served by any instance.
+type oidcFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+const oidcFlowMaxAge = 600
+
+func oidcCookieName(provider string) string {
+	return "oidc_" + provider
+}
+
+// oidcLogin redirects to provider with new state, nonce and PKCE verifier.
+func (sh *ServerHandler) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	provider := sh.redirectProvider(name)
+	if provider == nil {
+		WriteError(w, ErrUnknownProvider)
+		return
+	}
+	flow := oidcFlow{}
+	for _, value := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		token, err := generateToken()
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		*value = token
+	}
+	data, err := json.Marshal(&flow)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName(name),
+		Value:    base64.RawURLEncoding.EncodeToString(data),
+		Path:     "/api/accounts/oidc/" + name,
+		MaxAge:   oidcFlowMaxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(flow.State, flow.Verifier, flow.Nonce), http.StatusFound)
+}
+
+func readOIDCFlow(r *http.Request, name string) *oidcFlow {
+	cookie, err := r.Cookie(oidcCookieName(name))
+	if err != nil {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	flow := oidcFlow{}
+	if json.Unmarshal(data, &flow) != nil || flow.State == "" {
+		return nil
+	}
+	return &flow
+}
+
+// oidcCallback exchanges code of provider, provisions account and logs it in.
+func (sh *ServerHandler) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	provider := sh.redirectProvider(name)
+	if provider == nil {
+		WriteError(w, ErrUnknownProvider)
+		return
+	}
+	flow := readOIDCFlow(r, name)
+	query := r.URL.Query()
+	if flow == nil || query.Get("state") != flow.State {
+		WriteError(w, ErrBadOIDCState)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName(name), Path: "/api/accounts/oidc/" + name, MaxAge: -1})
+	if errCode := query.Get("error"); errCode != "" {
+		WriteError(w, ErrProviderRefused)
+		return
+	}
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		log.Printf("Error at exchange code of %s: %s", name, err)
+		WriteError(w, ErrProviderRefused)
+		return
+	}
+	auditEvent(r).Actor = identity.Login
+	acc, err := sh.provisionExternal(r.Context(), identity)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	sh.completeLogin(w, r, acc)
+}
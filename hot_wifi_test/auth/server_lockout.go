@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// authenticate checks login and password of request taking lockout of login into
+// account. It answers with error itself and returns nil if account is not authenticated.
+// Unknown login and wrong password get the same answer in the same time. Lockouts are
+// kept by normalised login, so changing case of login does not get new attempts.
+func (sh *ServerHandler) authenticate(ctx context.Context, w http.ResponseWriter, login, password string) *Account {
+	key := NormalizeLogin(login)
+	if !sh.beginAttempt(ctx, w, key) {
+		return nil
+	}
+
+	acc, err := sh.findAccount(ctx, login)
+	if err != nil {
+		WriteError(w, err)
+		return nil
+	}
+	ok, upgraded := false, false
+	if acc != nil && !acc.IsExternalAccount {
+		ok, upgraded, err = acc.CheckPassword(sh.hashing, password)
+		if err != nil {
+			WriteError(w, err)
+			return nil
+		}
+	} else if provider := sh.accountPasswordProvider(acc); provider != nil {
+		var identity *ExternalIdentity
+		identity, err = provider.Authenticate(login, password)
+		if err == nil {
+			acc, err = sh.provisionExternal(ctx, identity)
+		}
+		if err != nil && err != ErrBadCredentials && err != ErrExternalLoginTaken {
+			WriteError(w, err)
+			return nil
+		}
+		ok = err == nil
+	} else {
+		verifyDummyPassword(sh.hashing, password)
+	}
+	if !ok {
+		sh.metrics.Login(LoginFailure)
+		WriteError(w, ErrBadCredentials)
+		return nil
+	}
+
+	err = sh.lockoutsStorage.DeleteLockout(ctx, key)
+	if err != nil {
+		WriteError(w, err)
+		return nil
+	}
+	if upgraded {
+		err = sh.accountsStorage.UpdateAccount(ctx, acc)
+		if err != nil {
+			WriteError(w, err)
+			return nil
+		}
+	}
+	return acc
+}
+
+// findAccount returns account by normalised login. Accounts created before logins were
+// normalised are found by login as it was given.
+func (sh *ServerHandler) findAccount(ctx context.Context, login string) (*Account, error) {
+	acc, err := sh.accountsStorage.GetAccount(ctx, NormalizeLogin(login))
+	if acc != nil || err != nil || NormalizeLogin(login) == login {
+		return acc, err
+	}
+	return sh.accountsStorage.GetAccount(ctx, login)
+}
+
+// beginAttempt counts attempt to login as failure before credentials are checked, so
+// parallel guesses get distinct counters and can not pass threshold before lock is set.
+// Attempt which reaches threshold locks login at once, successful attempt must clear
+// lockout. Attempts to locked login are rejected without counting. It answers with error
+// itself and returns false if attempt is not allowed.
+func (sh *ServerHandler) beginAttempt(ctx context.Context, w http.ResponseWriter, login string) bool {
+	now := time.Now()
+	lockout, err := sh.lockoutsStorage.GetLockout(ctx, login)
+	if err != nil {
+		WriteError(w, err)
+		return false
+	}
+	if lockout != nil && lockout.IsLocked(now) {
+		sh.metrics.Login(LoginLocked)
+		WriteError(w, ErrAccountLocked)
+		return false
+	}
+	lockout, err = sh.lockoutsStorage.AddFailure(ctx, login, now)
+	if err != nil {
+		WriteError(w, err)
+		return false
+	}
+	// over threshold without lock means that parallel attempt has not set lock yet
+	overThreshold := lockout.Failures > sh.config.Lockout.Threshold && lockout.LockedUntil.IsZero()
+	if lockout.IsLocked(now) || overThreshold {
+		sh.metrics.Login(LoginLocked)
+		WriteError(w, ErrAccountLocked)
+		return false
+	}
+	if duration := sh.config.Lockout.LockDuration(lockout.Failures); duration > 0 {
+		log.Printf("Login %q is locked for %v after %d failures", login, duration, lockout.Failures)
+		err = sh.lockoutsStorage.SetLockedUntil(ctx, login, now.Add(duration))
+		if err != nil {
+			WriteError(w, err)
+			return false
+		}
+	}
+	return true
+}
+
+// RateLimited passes only requests from addresses which are not over limit.
+func RateLimited(limiter *RateLimiter, next HttpHandlerFunc) HttpHandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if !limiter.Allow(ClientIP(req)) {
+			WriteError(res, ErrTooManyRequests)
+			return
+		}
+		next(res, req)
+	}
+}
+
+func (sh *ServerHandler) getLockouts(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := sh.lockoutsStorage.GetLockouts(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, lockouts)
+}
+
+func (sh *ServerHandler) clearLockout(w http.ResponseWriter, r *http.Request) {
+	login := NormalizeLogin(mux.Vars(r)["login"])
+	auditEvent(r).Target = login
+	err := sh.lockoutsStorage.DeleteLockout(r.Context(), login)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func loginBody(login, password string) string {
+	data, _ := json.Marshal(&LoginData{Login: login, Password: password})
+	req, _ := http.NewRequest("POST", "/api/accounts/login", bytes.NewBuffer(data))
+	req.RemoteAddr = "127.0.0.1:12345"
+	return execResp(req).Body.String()
+}
+
+func TestLoginUniformErrors(t *testing.T) {
+	prepareAccount("uniform", "uniformPASS1")
+	unknown := loginBody("nobody", "uniformPASS1")
+	wrong := loginBody("uniform", "wrongPASS1")
+	if unknown != wrong || unknown != problemBody(401, "bad_credentials", "Bad login or password") {
+		t.Errorf("unknown login and wrong password must get the same answer: %v %v", unknown, wrong)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	prepareAccount("guessed", "guessedPASS1")
+	for i := 0; i < cfg.Lockout.Threshold; i++ {
+		loginBody("guessed", "wrongPASS1")
+	}
+	if body := loginBody("guessed", "guessedPASS1"); body != problemBody(429, "account_locked", "Too many failed login attempts, try later") {
+		t.Errorf("locked login must be rejected even with right password, got %v", body)
+	}
+	for i := 0; i < cfg.Lockout.Threshold; i++ {
+		loginBody("ghost", "wrongPASS1")
+	}
+	if body := loginBody("ghost", "wrongPASS1"); body != problemBody(429, "account_locked", "Too many failed login attempts, try later") {
+		t.Errorf("unknown login must be locked as well, got %v", body)
+	}
+
+	var lockouts []Lockout
+	json.Unmarshal([]byte(requestWithToken("GET", "/api/accounts/lockouts", sToken, nil)), &lockouts)
+	found := false
+	for _, l := range lockouts {
+		found = found || (l.Login == "guessed" && l.Failures == cfg.Lockout.Threshold && l.IsLocked(time.Now()))
+	}
+	if !found {
+		t.Errorf("lockout must be listed, got %v", lockouts)
+	}
+
+	if body := requestWithToken("DELETE", "/api/accounts/lockouts/guessed", sToken, nil); body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if token := loginAs("guessed", "guessedPASS1"); token == "" {
+		t.Errorf("cleared lockout must allow login")
+	}
+}
+
+func TestParallelGuessesStopAtThreshold(t *testing.T) {
+	prepareAccount("parallel", "parallelPASS1")
+	bodies := make(chan string, 3*cfg.Lockout.Threshold)
+	wg := sync.WaitGroup{}
+	for i := 0; i < cap(bodies); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies <- loginBody("parallel", "wrongPASS1")
+		}()
+	}
+	wg.Wait()
+	close(bodies)
+	checked := 0
+	for body := range bodies {
+		if body == problemBody(401, "bad_credentials", "Bad login or password") {
+			checked++
+		}
+	}
+	if checked > cfg.Lockout.Threshold {
+		t.Errorf("only %d guesses must be checked before lock, got %d", cfg.Lockout.Threshold, checked)
+	}
+}
+
+// brokenLockoutsStorage can not set lock.
+type brokenLockoutsStorage struct {
+	LockoutsStorage
+}
+
+func (st *brokenLockoutsStorage) SetLockedUntil(ctx context.Context, login string, until time.Time) error {
+	return errors.New("lockouts are not writable")
+}
+
+func TestLockErrorIsNotIgnored(t *testing.T) {
+	broken := Router(cfg, as, ps, ss, rs, rls, &brokenLockoutsStorage{NewMemoryLockoutsStorage(time.Hour)}, aus, tokens)
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < cfg.Lockout.Threshold; i++ {
+		data, _ := json.Marshal(&LoginData{Login: "unlockable", Password: "wrongPASS1"})
+		req, _ := http.NewRequest("POST", "/api/accounts/login", bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		rr = httptest.NewRecorder()
+		broken.ServeHTTP(rr, req)
+	}
+	if rr.Code != 500 {
+		t.Errorf("failed lock must be reported, got %v %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLoginSuccessResetsFailures(t *testing.T) {
+	ctx := context.Background()
+	prepareAccount("typo", "typoPASS1")
+	loginBody("typo", "wrongPASS1")
+	loginAs("typo", "typoPASS1")
+	if lockout, _ := ls.GetLockout(ctx, "typo"); lockout != nil {
+		t.Errorf("successful login must reset failures, got %+v", lockout)
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	base := time.Duration(cfg.Lockout.Duration) * time.Second
+	if cfg.Lockout.LockDuration(cfg.Lockout.Threshold-1) != 0 {
+		t.Errorf("login must not be locked before threshold")
+	}
+	if cfg.Lockout.LockDuration(cfg.Lockout.Threshold) != base || cfg.Lockout.LockDuration(cfg.Lockout.Threshold+1) != 2*base {
+		t.Errorf("lockout must double with every failure")
+	}
+	if cfg.Lockout.LockDuration(cfg.Lockout.Threshold+100) != time.Duration(cfg.Lockout.MaxDuration)*time.Second {
+		t.Errorf("lockout must be limited")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter(2, time.Minute)
+	rl.now = func() time.Time { return now }
+	if !rl.Allow("a") || !rl.Allow("a") || rl.Allow("a") {
+		t.Errorf("only two calls must be allowed")
+	}
+	if !rl.Allow("b") {
+		t.Errorf("other keys must have own limit")
+	}
+	now = now.Add(time.Minute)
+	if !rl.Allow("a") {
+		t.Errorf("limit must be reset in next window")
+	}
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	config := *cfg
+	config.Lockout.RateLimit = 2
+	limited := Router(&config, as, ps, ss, rs, rls, ls, aus, tokens)
+
+	var body string
+	for i := 0; i < 3; i++ {
+		data, _ := json.Marshal(&LoginData{Login: "flood", Password: "floodPASS1"})
+		req, _ := http.NewRequest("POST", "/api/accounts/login", bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "10.0.0.1:1000"
+		rr := httptest.NewRecorder()
+		limited.ServeHTTP(rr, req)
+		body = rr.Body.String()
+	}
+	if body != problemBody(429, "too_many_requests", "Too many login attempts from your address, try later") {
+		t.Errorf("third login must be limited, got %v", body)
+	}
+
+	data, _ := json.Marshal(&PasswordCheckData{Login: "flood", Password: "floodPASS1"})
+	req, _ := http.NewRequest("POST", "/api/accounts/password/check", bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "10.0.0.1:1000"
+	rr := httptest.NewRecorder()
+	limited.ServeHTTP(rr, req)
+	if rr.Code != 429 {
+		t.Errorf("password check must share limit of login, got %v %v", rr.Code, rr.Body.String())
+	}
+}
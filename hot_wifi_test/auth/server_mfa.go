@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+var ErrBadMFACode = NewError(KindUnauthenticated, "bad_mfa_code", "Bad two-factor code")
+var ErrMFAEnabled = NewError(KindConflict, "mfa_enabled", "Two-factor authentication is already enabled")
+var ErrMFARequired = NewError(KindForbidden, "mfa_required", "Two-factor authentication is required by policy")
+var ErrMFANotEnrolled = NewError(KindValidation, "mfa_not_enrolled", "Two-factor enrolment is not started")
+
+// MFAChallengeResponse is answer of login for account with second factor. MFAEnrol
+// means account has to enrol TOTP at /api/accounts/login/mfa/enrol first.
+type MFAChallengeResponse struct {
+	OK          bool   `json:"ok"`
+	MFARequired bool   `json:"mfa-required"`
+	MFAEnrol    bool   `json:"mfa-enrol"`
+	MFAToken    string `json:"mfa-token"`
+	ExpiresIn   int    `json:"expires-in"`
+}
+
+type TOTPEnrolment struct {
+	OK     bool   `json:"ok"`
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	OK            bool     `json:"ok"`
+	RecoveryCodes []string `json:"recovery-codes"`
+}
+
+type TOTPCodeData struct {
+	Code string `json:"code"`
+}
+
+type MFAData struct {
+	Token string `json:"mfa-token"`
+	Code  string `json:"code"`
+}
+
+func (sh *ServerHandler) writeMFAChallenge(w http.ResponseWriter, acc *Account) {
+	token, err := sh.authManager.tokens.IssueMFA(acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, MFAChallengeResponse{OK: true, MFARequired: true, MFAEnrol: !acc.TOTPEnabled, MFAToken: token, ExpiresIn: sh.config.MFA.TTL})
+}
+
+// startEnrolment makes new pending secret of account, it is enabled after confirmation.
+func (sh *ServerHandler) startEnrolment(ctx context.Context, w http.ResponseWriter, acc *Account) {
+	if acc.TOTPEnabled {
+		WriteError(w, ErrMFAEnabled)
+		return
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	acc.TOTPPending = secret
+	err = sh.accountsStorage.UpdateAccount(ctx, acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, TOTPEnrolment{OK: true, Secret: secret, URI: TOTPURI(sh.config.MFA.Issuer, acc.Login, secret)})
+}
+
+// confirmEnrolment enables pending secret if code is valid and returns new recovery codes.
+// Account must be saved by caller.
+func confirmEnrolment(acc *Account, code string) ([]string, error) {
+	if acc.TOTPPending == "" {
+		return nil, ErrMFANotEnrolled
+	}
+	step, ok := validateTOTP(acc.TOTPPending, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrBadMFACode
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	acc.TOTPEnabled = true
+	acc.TOTPSecret = acc.TOTPPending
+	acc.TOTPPending = ""
+	acc.TOTPLastStep = step
+	acc.RecoveryCodes = hashes
+	return codes, nil
+}
+
+func (sh *ServerHandler) enrolTOTP(w http.ResponseWriter, r *http.Request, acc *Account) {
+	sh.startEnrolment(r.Context(), w, acc)
+}
+
+func readCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var codeData TOTPCodeData
+	err := decodeJSON(w, r, &codeData)
+	if err != nil {
+		WriteError(w, err)
+		return "", false
+	}
+	return codeData.Code, true
+}
+
+func (sh *ServerHandler) confirmTOTP(w http.ResponseWriter, r *http.Request, acc *Account) {
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+	codes, err := confirmEnrolment(acc, code)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	err = sh.accountsStorage.UpdateAccount(r.Context(), acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, RecoveryCodesResponse{OK: true, RecoveryCodes: codes})
+}
+
+// verifyOwnCode checks second factor of logged in account. Codes are counted as login
+// attempts, so stolen session does not allow to guess them. It answers with error itself
+// and returns false if code is not accepted.
+func (sh *ServerHandler) verifyOwnCode(ctx context.Context, w http.ResponseWriter, acc *Account, code string) bool {
+	if !sh.beginAttempt(ctx, w, acc.Login) {
+		return false
+	}
+	if !acc.TOTPEnabled || !acc.VerifySecondFactor(code, time.Now()) {
+		WriteError(w, ErrBadMFACode)
+		return false
+	}
+	err := sh.lockoutsStorage.DeleteLockout(ctx, acc.Login)
+	if err != nil {
+		WriteError(w, err)
+		return false
+	}
+	return true
+}
+
+func (sh *ServerHandler) disableTOTP(w http.ResponseWriter, r *http.Request, acc *Account) {
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+	policy, err := sh.policyStorage.GetPolicy(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if policy.Requires2FA(acc, sh.config.IsSupervisor(acc)) {
+		WriteError(w, ErrMFARequired)
+		return
+	}
+	if !sh.verifyOwnCode(r.Context(), w, acc, code) {
+		return
+	}
+	acc.TOTPEnabled = false
+	acc.TOTPSecret = ""
+	acc.TOTPLastStep = 0
+	acc.RecoveryCodes = nil
+	err = sh.accountsStorage.UpdateAccount(r.Context(), acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
+
+func (sh *ServerHandler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, acc *Account) {
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+	if !sh.verifyOwnCode(r.Context(), w, acc, code) {
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	acc.RecoveryCodes = hashes
+	err = sh.accountsStorage.UpdateAccount(r.Context(), acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, RecoveryCodesResponse{OK: true, RecoveryCodes: codes})
+}
+
+func readMFAData(w http.ResponseWriter, r *http.Request) *MFAData {
+	var mfaData MFAData
+	err := decodeJSON(w, r, &mfaData)
+	if err != nil {
+		WriteError(w, err)
+		return nil
+	}
+	return &mfaData
+}
+
+// accountFromMFAToken returns account which passed first step of login.
+func (sh *ServerHandler) accountFromMFAToken(ctx context.Context, w http.ResponseWriter, token string) *Account {
+	claims, err := sh.authManager.tokens.ParseMFA(token)
+	if err != nil {
+		WriteError(w, err)
+		return nil
+	}
+	acc, err := sh.accountsStorage.GetAccount(ctx, claims.Login)
+	if err != nil {
+		WriteError(w, err)
+		return nil
+	}
+	if acc == nil || acc.ID == nil || acc.ID.Hex() != claims.AccountID {
+		WriteError(w, ErrInvalidToken)
+		return nil
+	}
+	return acc
+}
+
+func (sh *ServerHandler) loginMFAEnrol(w http.ResponseWriter, r *http.Request) {
+	mfaData := readMFAData(w, r)
+	if mfaData == nil {
+		return
+	}
+	acc := sh.accountFromMFAToken(r.Context(), w, mfaData.Token)
+	if acc == nil {
+		return
+	}
+	auditEvent(r).Actor = acc.Login
+	sh.startEnrolment(r.Context(), w, acc)
+}
+
+// loginMFA is second step of login. Code is checked by enabled secret or, for account
+// which enrols at login, by pending one.
+func (sh *ServerHandler) loginMFA(w http.ResponseWriter, r *http.Request) {
+	mfaData := readMFAData(w, r)
+	if mfaData == nil {
+		return
+	}
+	acc := sh.accountFromMFAToken(r.Context(), w, mfaData.Token)
+	if acc == nil {
+		return
+	}
+	auditEvent(r).Actor = acc.Login
+	if !sh.beginAttempt(r.Context(), w, acc.Login) {
+		return
+	}
+
+	now := time.Now()
+	var err error
+	var codes []string
+	if acc.TOTPEnabled {
+		if !acc.VerifySecondFactor(mfaData.Code, now) {
+			err = ErrBadMFACode
+		}
+	} else {
+		codes, err = confirmEnrolment(acc, mfaData.Code)
+	}
+	if err == ErrBadMFACode || err == ErrMFANotEnrolled {
+		sh.metrics.Login(LoginFailure)
+		WriteError(w, err)
+		return
+	}
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	err = sh.lockoutsStorage.DeleteLockout(r.Context(), acc.Login)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	err = sh.accountsStorage.UpdateAccount(r.Context(), acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	sess, err := sh.authManager.Login(r.Context(), acc, r.UserAgent(), ClientIP(r))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	sh.metrics.Login(LoginSuccess)
+	resp := sh.loginResponse(sess)
+	resp.RecoveryCodes = codes
+	WriteOK(w, resp)
+}
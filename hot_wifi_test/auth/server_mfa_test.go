@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func postJson(url, token string, data interface{}) *http.Response {
+	body, _ := json.Marshal(data)
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	req.RemoteAddr = "127.0.0.1:12345"
+	if token != "" {
+		req.Header.Set(cfg.HeaderName, token)
+	}
+	return execResp(req).Result()
+}
+
+func decodeJson(resp *http.Response, to interface{}) {
+	json.NewDecoder(resp.Body).Decode(to)
+}
+
+// nextCode returns code of next time step, so it is not rejected as replay of just used one.
+func nextCode(secret string) string {
+	code, _ := totpCode(secret, time.Now().Unix()/totpPeriod+1)
+	return code
+}
+
+func mfaChallenge(login, password string) MFAChallengeResponse {
+	var challenge MFAChallengeResponse
+	decodeJson(postJson("/api/accounts/login", "", LoginData{Login: login, Password: password}), &challenge)
+	return challenge
+}
+
+func TestTOTPEnrolmentAndLogin(t *testing.T) {
+	ctx := context.Background()
+	prepareAccount("twofactor", "twofactorPASS1")
+	token := loginAs("twofactor", "twofactorPASS1")
+
+	var enrolment TOTPEnrolment
+	decodeJson(postJson("/api/accounts/2fa/enrol", token, nil), &enrolment)
+	if !enrolment.OK || enrolment.Secret == "" {
+		t.Fatalf("unexpected enrolment: %+v", enrolment)
+	}
+	if token := loginAs("twofactor", "twofactorPASS1"); token == "" {
+		t.Errorf("not confirmed enrolment must not require second factor")
+	}
+
+	var codes RecoveryCodesResponse
+	decodeJson(postJson("/api/accounts/2fa/confirm", token, TOTPCodeData{Code: "000000"}), &codes)
+	if codes.OK {
+		t.Fatalf("bad code must not confirm enrolment")
+	}
+	code, _ := totpCode(enrolment.Secret, time.Now().Unix()/totpPeriod)
+	decodeJson(postJson("/api/accounts/2fa/confirm", token, TOTPCodeData{Code: code}), &codes)
+	if !codes.OK || len(codes.RecoveryCodes) != recoveryCodesCount {
+		t.Fatalf("unexpected confirmation: %+v", codes)
+	}
+
+	challenge := mfaChallenge("twofactor", "twofactorPASS1")
+	if !challenge.MFARequired || challenge.MFAEnrol || challenge.MFAToken == "" {
+		t.Fatalf("login must return challenge, got %+v", challenge)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, challenge.MFAToken); acc != nil {
+		t.Errorf("challenge token must not be accepted as access token")
+	}
+
+	var resp LoginResponse
+	decodeJson(postJson("/api/accounts/login/mfa", "", MFAData{Token: challenge.MFAToken, Code: "000000"}), &resp)
+	if resp.OK {
+		t.Errorf("bad code must be rejected")
+	}
+	decodeJson(postJson("/api/accounts/login/mfa", "", MFAData{Token: challenge.MFAToken, Code: nextCode(enrolment.Secret)}), &resp)
+	if !resp.OK || resp.Token == "" {
+		t.Fatalf("login with code must succeed, got %+v", resp)
+	}
+
+	resp = LoginResponse{}
+	decodeJson(postJson("/api/accounts/login/mfa", "", MFAData{Token: challenge.MFAToken, Code: codes.RecoveryCodes[0]}), &resp)
+	if !resp.OK {
+		t.Errorf("login with recovery code must succeed")
+	}
+	resp = LoginResponse{}
+	decodeJson(postJson("/api/accounts/login/mfa", "", MFAData{Token: challenge.MFAToken, Code: codes.RecoveryCodes[0]}), &resp)
+	if resp.OK {
+		t.Errorf("recovery code must be used once")
+	}
+
+	var renewed RecoveryCodesResponse
+	decodeJson(postJson("/api/accounts/2fa/recovery-codes", token, TOTPCodeData{Code: codes.RecoveryCodes[1]}), &renewed)
+	if !renewed.OK || len(renewed.RecoveryCodes) != recoveryCodesCount {
+		t.Fatalf("unexpected recovery codes: %+v", renewed)
+	}
+	req, _ := http.NewRequest("DELETE", "/api/accounts/2fa", bytes.NewBufferString(`{"code":"`+codes.RecoveryCodes[2]+`"}`))
+	req.Header.Set(cfg.HeaderName, token)
+	if body := execResp(req).Body.String(); body != problemBody(401, "bad_mfa_code", "Bad two-factor code") {
+		t.Errorf("old recovery codes must be replaced, got %v", body)
+	}
+	req, _ = http.NewRequest("DELETE", "/api/accounts/2fa", bytes.NewBufferString(`{"code":"`+renewed.RecoveryCodes[0]+`"}`))
+	req.Header.Set(cfg.HeaderName, token)
+	if body := execResp(req).Body.String(); body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if token := loginAs("twofactor", "twofactorPASS1"); token == "" {
+		t.Errorf("disabled second factor must not be required")
+	}
+}
+
+func TestTOTPCodeGuessesAreLocked(t *testing.T) {
+	prepareAccount("codeguess", "codeguessPASS1")
+	token := loginAs("codeguess", "codeguessPASS1")
+	var enrolment TOTPEnrolment
+	decodeJson(postJson("/api/accounts/2fa/enrol", token, nil), &enrolment)
+	code, _ := totpCode(enrolment.Secret, time.Now().Unix()/totpPeriod)
+	postJson("/api/accounts/2fa/confirm", token, TOTPCodeData{Code: code})
+
+	for i := 0; i < cfg.Lockout.Threshold; i++ {
+		postJson("/api/accounts/2fa/recovery-codes", token, TOTPCodeData{Code: "000000"})
+	}
+	req, _ := http.NewRequest("DELETE", "/api/accounts/2fa", bytes.NewBufferString(`{"code":"`+nextCode(enrolment.Secret)+`"}`))
+	req.Header.Set(cfg.HeaderName, token)
+	if body := execResp(req).Body.String(); body != problemBody(429, "account_locked", ErrAccountLocked.Message) {
+		t.Errorf("guessed codes must lock account, got %v", body)
+	}
+}
+
+func TestTOTPRequiredByPolicy(t *testing.T) {
+	setTestPolicy(&PasswordPolicy{Require2FA: Require2FAAll})
+	defer setTestPolicy(DEFAULT_POLICY)
+	prepareAccount("obliged", "obligedPASS1")
+
+	challenge := mfaChallenge("obliged", "obligedPASS1")
+	if !challenge.MFARequired || !challenge.MFAEnrol {
+		t.Fatalf("account without TOTP must enrol at login, got %+v", challenge)
+	}
+	var enrolment TOTPEnrolment
+	decodeJson(postJson("/api/accounts/login/mfa/enrol", "", MFAData{Token: challenge.MFAToken}), &enrolment)
+	if enrolment.Secret == "" {
+		t.Fatalf("unexpected enrolment: %+v", enrolment)
+	}
+	code, _ := totpCode(enrolment.Secret, time.Now().Unix()/totpPeriod)
+	var resp LoginResponse
+	decodeJson(postJson("/api/accounts/login/mfa", "", MFAData{Token: challenge.MFAToken, Code: code}), &resp)
+	if !resp.OK || resp.Token == "" || len(resp.RecoveryCodes) != recoveryCodesCount {
+		t.Fatalf("enrolment at login must finish login, got %+v", resp)
+	}
+
+	req, _ := http.NewRequest("DELETE", "/api/accounts/2fa", bytes.NewBufferString(`{"code":"`+resp.RecoveryCodes[0]+`"}`))
+	req.Header.Set(cfg.HeaderName, resp.Token)
+	if body := execResp(req).Body.String(); body != problemBody(403, "mfa_required", "Two-factor authentication is required by policy") {
+		t.Errorf("required second factor must not be disabled, got %v", body)
+	}
+}
+
+func TestTOTPRequiredForSupervisors(t *testing.T) {
+	policy := PasswordPolicy{Require2FA: Require2FASupervisors}
+	if policy.Requires2FA(&Account{Login: "plain"}, false) {
+		t.Errorf("plain account must not require second factor")
+	}
+	if !policy.Requires2FA(&Account{Login: cfg.Supervisor.Login}, true) || !policy.Requires2FA(&Account{Login: "admin", Roles: []string{"admins"}}, false) {
+		t.Errorf("supervisors must require second factor")
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"net/http"
+)
+
+var ErrPasswordInvalid = NewError(KindValidation, "password_invalid", "Password is invalid")
+var ErrNewPasswordInvalid = NewError(KindValidation, "new_password_invalid", "New password is invalid")
+
+func (sh *ServerHandler) getPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, err := sh.policyStorage.GetPolicy(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	setETag(w, policy.Version)
+	WriteOK(w, policy)
+}
+
+type PasswordCheckData struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type PasswordCheckResponse struct {
+	OK         bool              `json:"ok"`
+	Valid      bool              `json:"valid"`
+	Violations []PolicyViolation `json:"violations"`
+}
+
+// checkPassword validates password against policy without saving it. If request has
+// valid token password is checked as new password of its account, including history.
+// History check hashes password many times, so route is rate limited as login.
+func (sh *ServerHandler) checkPassword(w http.ResponseWriter, r *http.Request) {
+	var checkData PasswordCheckData
+	err := decodeJSON(w, r, &checkData)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	policy, err := sh.policyStorage.GetPolicy(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	acc, _ := sh.authManager.FromToken(r.Context(), r.Header.Get(sh.config.HeaderName))
+	if acc == nil {
+		acc = &Account{Login: checkData.Login}
+	}
+	violations := policy.CheckAccountPassword(sh.hashing, acc, checkData.Password)
+	WriteOK(w, PasswordCheckResponse{OK: true, Valid: len(violations) == 0, Violations: violations})
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGetPolicy(t *testing.T) {
+	setTestPolicy(&PasswordPolicy{Length: 10, MinNumbers: 2, BlockCommon: true})
+	defer setTestPolicy(DEFAULT_POLICY)
+
+	req, _ := http.NewRequest("GET", "/api/accounts/password/policy", nil)
+	rr := execResp(req)
+	var policy PasswordPolicy
+	if err := json.Unmarshal(rr.Body.Bytes(), &policy); err != nil {
+		t.Fatalf("unexpected body: %s", rr.Body.String())
+	}
+	if policy.Length != 10 || policy.MinNumbers != 2 || !policy.BlockCommon {
+		t.Errorf("policy must be readable without login, got %+v", policy)
+	}
+}
+
+func checkPasswordAs(token string, data PasswordCheckData) PasswordCheckResponse {
+	body, _ := json.Marshal(&data)
+	req, _ := http.NewRequest("POST", "/api/accounts/password/check", bytes.NewBuffer(body))
+	if token != "" {
+		req.Header.Set(cfg.HeaderName, token)
+	}
+	rr := execResp(req)
+	var resp PasswordCheckResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return resp
+}
+
+func TestCheckPassword(t *testing.T) {
+	setTestPolicy(&PasswordPolicy{Length: 8, Numbers: true, DisallowLogin: true, HistoryDepth: 3})
+	defer setTestPolicy(DEFAULT_POLICY)
+
+	resp := checkPasswordAs("", PasswordCheckData{Login: "checker", Password: "checker"})
+	codes := violationCodes(resp.Violations)
+	if !resp.OK || resp.Valid || !codes[ViolationMinLength] || !codes[ViolationNumbers] || !codes[ViolationContainsLogin] {
+		t.Errorf("all violations must be reported, got %+v", resp)
+	}
+
+	resp = checkPasswordAs("", PasswordCheckData{Login: "checker", Password: "longpassword1"})
+	if !resp.Valid || len(resp.Violations) != 0 {
+		t.Errorf("password must be valid, got %+v", resp)
+	}
+
+	prepareAccount("checker", "longpassword1")
+	token := loginAs("checker", "longpassword1")
+	resp = checkPasswordAs(token, PasswordCheckData{Password: "longpassword1"})
+	if resp.Valid || !violationCodes(resp.Violations)[ViolationReused] {
+		t.Errorf("current password of logged in account must be reported as reused, got %+v", resp)
+	}
+}
+
+func TestPolicyViolationsResponse(t *testing.T) {
+	setTestPolicy(&PasswordPolicy{Length: 8, Numbers: true})
+	defer setTestPolicy(DEFAULT_POLICY)
+
+	data, _ := json.Marshal(&AccountCreateData{Login: "weak", Password: "short"})
+	req, _ := http.NewRequest("POST", "/accounts", bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, sToken)
+	rr := execResp(req)
+	var resp Problem
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	codes := violationCodes(resp.Violations)
+	if rr.Code != 400 || resp.Code != "password_invalid" || !codes[ViolationMinLength] || !codes[ViolationNumbers] {
+		t.Errorf("create must answer with violations, got %s", rr.Body.String())
+	}
+
+	acc := prepareAccount("weakchange", "goodpassword1")
+	token := loginAs("weakchange", "goodpassword1")
+	data, _ = json.Marshal(&ChangePasswordData{Old: "goodpassword1", New: "bad"})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/accounts/%s/password", acc.ID.Hex()), bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, token)
+	req.Header.Set("If-Match", accountETag(acc))
+	rr = execResp(req)
+	resp = Problem{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != 400 || resp.Code != "new_password_invalid" || !violationCodes(resp.Violations)[ViolationMinLength] {
+		t.Errorf("change must answer with violations, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (sh *ServerHandler) getRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := sh.rolesStorage.GetRoles(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, roles)
+}
+
+type RoleData struct {
+	Permissions []string `json:"permissions"`
+}
+
+// setRole creates role or replaces its permissions.
+func (sh *ServerHandler) setRole(w http.ResponseWriter, r *http.Request) {
+	auditEvent(r).Target = mux.Vars(r)["name"]
+	var roleData RoleData
+	err := decodeJSON(w, r, &roleData)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	role := Role{Name: mux.Vars(r)["name"], Permissions: roleData.Permissions}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	err = role.Validate()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	err = sh.rolesStorage.SetRole(r.Context(), &role)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
+
+func (sh *ServerHandler) deleteRole(w http.ResponseWriter, r *http.Request) {
+	auditEvent(r).Target = mux.Vars(r)["name"]
+	err := sh.rolesStorage.DeleteRole(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
+
+type AccountRolesData struct {
+	Roles []string `json:"roles"`
+}
+
+// setAccountRoles replaces roles of account, every role must exist.
+func (sh *ServerHandler) setAccountRoles(w http.ResponseWriter, r *http.Request) {
+	acc := sh.accountFromVars(w, r)
+	if acc == nil {
+		return
+	}
+	var rolesData AccountRolesData
+	err := decodeJSON(w, r, &rolesData)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if !ifMatch(w, r, acc.Version) {
+		return
+	}
+	for _, name := range rolesData.Roles {
+		_, err := sh.rolesStorage.GetRole(r.Context(), name)
+		if err == ErrRoleNotFound {
+			WriteError(w, NewError(KindValidation, "unknown_role", "Unknown role %q", name))
+			return
+		}
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+	}
+	acc.Roles = rolesData.Roles
+	err = sh.accountsStorage.UpdateAccount(r.Context(), acc)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
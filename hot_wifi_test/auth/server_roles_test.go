@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func putWithToken(url, token string, data interface{}, headers ...string) string {
+	return requestWithToken("PUT", url, token, data, headers...)
+}
+
+func TestRolePermissions(t *testing.T) {
+	ctx := context.Background()
+	acc := prepareAccount("delegate", "delegatePASS1")
+	token := loginAs("delegate", "delegatePASS1")
+
+	req, _ := http.NewRequest("GET", "/accounts", nil)
+	req.Header.Set(cfg.HeaderName, token)
+	if body := execResp(req).Body.String(); body != problemBody(403, "permission_denied", "You have not permission accounts:read") {
+		t.Errorf("account without roles must not list accounts, got %v", body)
+	}
+
+	body := putWithToken("/api/roles/admins", sToken, RoleData{Permissions: []string{PermAccountsRead, PermAccountsWrite}})
+	if body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	rolesUrl := fmt.Sprintf("/api/accounts/%s/roles", acc.ID.Hex())
+	if body := putWithToken(rolesUrl, token, AccountRolesData{Roles: []string{"admins"}}); body != problemBody(403, "permission_denied", "You have not permission roles:write") {
+		t.Errorf("account must not assign roles to itself, got %v", body)
+	}
+	if body := putWithToken(rolesUrl, sToken, AccountRolesData{Roles: []string{"admins"}}, "If-Match", accountETag(acc)); body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+
+	req, _ = http.NewRequest("GET", "/accounts", nil)
+	req.Header.Set(cfg.HeaderName, token)
+	rr := execResp(req)
+	var views []AccountView
+	if err := json.Unmarshal(rr.Body.Bytes(), &views); err != nil {
+		t.Fatalf("account with role must list accounts, got %v", rr.Body.String())
+	}
+
+	data, _ := json.Marshal(&AccountCreateData{Login: "delegated", Password: "delegatedPASS1"})
+	req, _ = http.NewRequest("POST", "/accounts", bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, token)
+	execResp(req)
+	if created, _ := as.GetAccount(ctx, "delegated"); created == nil {
+		t.Errorf("account with role must create accounts")
+	}
+
+	data, _ = json.Marshal(&PasswordPolicy{Length: 1})
+	req, _ = http.NewRequest("POST", "/api/accounts/password/policy", bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, token)
+	if body := execResp(req).Body.String(); body != problemBody(403, "permission_denied", "You have not permission policy:write") {
+		t.Errorf("role without policy:write must not set policy, got %v", body)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/roles/admins", nil)
+	req.Header.Set(cfg.HeaderName, sToken)
+	execResp(req)
+	if ok, _ := sh.authManager.HasPermission(ctx, acc, PermAccountsRead); ok {
+		t.Errorf("permissions of deleted role must be dropped")
+	}
+}
+
+func TestRoleValidation(t *testing.T) {
+	body := putWithToken("/api/roles/broken", sToken, RoleData{Permissions: []string{"everything"}})
+	if body != problemBody(400, "bad_role", `Unknown permission "everything"`) {
+		t.Errorf("unknown permission must be rejected, got %v", body)
+	}
+
+	acc := prepareAccount("roleless", "rolelessPASS1")
+	body = putWithToken(fmt.Sprintf("/api/accounts/%s/roles", acc.ID.Hex()), sToken, AccountRolesData{Roles: []string{"missing"}}, "If-Match", accountETag(acc))
+	if body != problemBody(400, "unknown_role", `Unknown role "missing"`) {
+		t.Errorf("unknown role must be rejected, got %v", body)
+	}
+
+	putWithToken("/api/roles/readers", sToken, RoleData{Permissions: []string{PermRolesRead}})
+	req, _ := http.NewRequest("GET", "/api/roles", nil)
+	req.Header.Set(cfg.HeaderName, sToken)
+	var roles []Role
+	json.Unmarshal(execResp(req).Body.Bytes(), &roles)
+	found := false
+	for _, role := range roles {
+		found = found || (role.Name == "readers" && role.Has(PermRolesRead))
+	}
+	if !found {
+		t.Errorf("stored role must be listed, got %v", roles)
+	}
+}
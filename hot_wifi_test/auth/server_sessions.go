@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrSessionNotFound = NewError(KindNotFound, "session_not_found", "Session not found")
+
+type SessionView struct {
+	Session
+	Current bool `json:"current"`
+}
+
+func sessionViews(sessions []Session, currentId string) []SessionView {
+	result := []SessionView{}
+	for _, s := range sessions {
+		result = append(result, SessionView{Session: s, Current: s.ID == currentId})
+	}
+	return result
+}
+
+// getSessions lists sessions of account of request and sends ETag of account, which its
+// owner needs to change password.
+func (sh *ServerHandler) getSessions(w http.ResponseWriter, r *http.Request, acc *Account, sess *Session) {
+	sessions, err := sh.authManager.Sessions(r.Context(), acc.Login)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	setETag(w, acc.Version)
+	WriteOK(w, sessionViews(sessions, sess.ID))
+}
+
+// revokeSessions logs account of request out everywhere, including session of request.
+func (sh *ServerHandler) revokeSessions(w http.ResponseWriter, r *http.Request, acc *Account, sess *Session) {
+	auditEvent(r).Target = acc.Login
+	err := sh.authManager.Logout(r.Context(), acc.Login)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
+
+func (sh *ServerHandler) revokeSession(w http.ResponseWriter, r *http.Request, acc *Account, sess *Session) {
+	found, err := sh.authManager.Revoke(r.Context(), acc.Login, mux.Vars(r)["sid"])
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if !found {
+		WriteError(w, ErrSessionNotFound)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
+
+func (sh *ServerHandler) accountFromVars(w http.ResponseWriter, r *http.Request) *Account {
+	auditEvent(r).Target = mux.Vars(r)["id"]
+	acc, err := sh.accountsStorage.GetAccountById(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		WriteError(w, err)
+		return nil
+	}
+	auditEvent(r).Target = acc.Login
+	return acc
+}
+
+func (sh *ServerHandler) getAccountSessions(w http.ResponseWriter, r *http.Request) {
+	acc := sh.accountFromVars(w, r)
+	if acc == nil {
+		return
+	}
+	sessions, err := sh.authManager.Sessions(r.Context(), acc.Login)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, sessionViews(sessions, ""))
+}
+
+func (sh *ServerHandler) revokeAccountSessions(w http.ResponseWriter, r *http.Request) {
+	acc := sh.accountFromVars(w, r)
+	if acc == nil {
+		return
+	}
+	err := sh.authManager.Logout(r.Context(), acc.Login)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
+
+func (sh *ServerHandler) revokeAccountSession(w http.ResponseWriter, r *http.Request) {
+	acc := sh.accountFromVars(w, r)
+	if acc == nil {
+		return
+	}
+	found, err := sh.authManager.Revoke(r.Context(), acc.Login, mux.Vars(r)["sid"])
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if !found {
+		WriteError(w, ErrSessionNotFound)
+		return
+	}
+	WriteOK(w, OkResponse{OK: true})
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func getSessionViews(t *testing.T, url, token string) []SessionView {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set(cfg.HeaderName, token)
+	rr := execResp(req)
+	var views []SessionView
+	err := json.Unmarshal(rr.Body.Bytes(), &views)
+	if err != nil {
+		t.Fatalf("unexpected body: %v", rr.Body.String())
+	}
+	return views
+}
+
+func deleteWithToken(url, token string) string {
+	req, _ := http.NewRequest("DELETE", url, nil)
+	req.Header.Set(cfg.HeaderName, token)
+	return execResp(req).Body.String()
+}
+
+func TestSessionsAreIndependent(t *testing.T) {
+	ctx := context.Background()
+	prepareAccount("multi", "multiPASS1")
+	phone := loginAs("multi", "multiPASS1", "User-Agent", "phone")
+	laptop := loginAs("multi", "multiPASS1", "User-Agent", "laptop")
+
+	for _, token := range []string{phone, laptop} {
+		if acc, _ := sh.authManager.FromToken(ctx, token); acc == nil {
+			t.Errorf("both sessions must be alive")
+		}
+	}
+
+	views := getSessionViews(t, "/api/accounts/sessions", laptop)
+	if len(views) != 2 {
+		t.Fatalf("must be two sessions, got %v", views)
+	}
+	if views[0].UserAgent != "phone" || views[0].Current || views[1].UserAgent != "laptop" || !views[1].Current {
+		t.Errorf("unexpected sessions %v", views)
+	}
+	if views[0].IP == "" || views[0].Created.IsZero() || views[0].LastSeen.IsZero() {
+		t.Errorf("session details must be filled, got %v", views[0])
+	}
+
+	body := deleteWithToken(fmt.Sprintf("/api/accounts/sessions/%s", views[0].ID), laptop)
+	if body != `{"ok":true}` {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, phone); acc != nil {
+		t.Errorf("revoked session must be rejected")
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, laptop); acc == nil {
+		t.Errorf("current session must be kept")
+	}
+
+	loginAs("multi", "multiPASS1")
+	body = deleteWithToken("/api/accounts/sessions", laptop)
+	if body != `{"ok":true}` {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if sessions, _ := ss.GetSessions(ctx, "multi"); len(sessions) != 0 {
+		t.Errorf("all sessions must be revoked, got %v", sessions)
+	}
+}
+
+func TestSessionOfOtherAccountCanNotBeRevoked(t *testing.T) {
+	ctx := context.Background()
+	prepareAccount("victim", "victimPASS1")
+	prepareAccount("attacker", "attackerPASS1")
+	victim := loginAs("victim", "victimPASS1")
+	attacker := loginAs("attacker", "attackerPASS1")
+
+	sessions, _ := ss.GetSessions(ctx, "victim")
+	body := deleteWithToken(fmt.Sprintf("/api/accounts/sessions/%s", sessions[0].ID), attacker)
+	if body != problemBody(404, "session_not_found", "Session not found") {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, victim); acc == nil {
+		t.Errorf("session of other account must be kept")
+	}
+}
+
+func TestSupervisorSessions(t *testing.T) {
+	ctx := context.Background()
+	acc := prepareAccount("managed", "managedPASS1")
+	first := loginAs("managed", "managedPASS1")
+	second := loginAs("managed", "managedPASS1")
+
+	url := fmt.Sprintf("/api/accounts/%s/sessions", acc.ID.Hex())
+	views := getSessionViews(t, url, sToken)
+	if len(views) != 2 {
+		t.Fatalf("must be two sessions, got %v", views)
+	}
+
+	body := deleteWithToken(url, first)
+	if body != problemBody(403, "permission_denied", "You have not permission sessions:revoke") {
+		t.Errorf("unexpected body: %v", body)
+	}
+
+	body = deleteWithToken(fmt.Sprintf("%s/%s", url, views[0].ID), sToken)
+	if body != `{"ok":true}` {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, first); acc != nil {
+		t.Errorf("revoked session must be rejected")
+	}
+
+	body = deleteWithToken(url, sToken)
+	if body != `{"ok":true}` {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, second); acc != nil {
+		t.Errorf("all sessions must be revoked")
+	}
+}
+
+func TestLogoutRevokesOnlyOwnSession(t *testing.T) {
+	ctx := context.Background()
+	prepareAccount("leaving", "leavingPASS1")
+	phone := loginAs("leaving", "leavingPASS1")
+	laptop := loginAs("leaving", "leavingPASS1")
+
+	body := requestWithToken("POST", "/api/accounts/logout", phone, LoginData{Login: cfg.Supervisor.Login})
+	if body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, phone); acc != nil {
+		t.Errorf("session of token must be revoked")
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, laptop); acc == nil {
+		t.Errorf("other session of account must be kept")
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, sToken); acc == nil {
+		t.Errorf("login in body must not log out other account")
+	}
+
+	tablet := loginAs("leaving", "leavingPASS1")
+	if body := requestWithToken("POST", "/api/accounts/logout/all", laptop, nil); body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if sessions, _ := ss.GetSessions(ctx, "leaving"); len(sessions) != 0 {
+		t.Errorf("all sessions must be revoked, got %v", sessions)
+	}
+	if body := requestWithToken("POST", "/api/accounts/logout", tablet, nil); body != problemBody(401, "unauthenticated", "You must login") {
+		t.Errorf("revoked token must not log out, got %v", body)
+	}
+}
+
+func TestForcedLogoutNeedsPermission(t *testing.T) {
+	ctx := context.Background()
+	target := prepareAccount("forced", "forcedPASS1")
+	token := loginAs("forced", "forcedPASS1")
+	prepareAccount("intruder", "intruderPASS1")
+	intruder := loginAs("intruder", "intruderPASS1")
+	url := fmt.Sprintf("/api/accounts/%s/logout", target.ID.Hex())
+
+	if body := requestWithToken("POST", url, intruder, nil); body != problemBody(403, "permission_denied", "You have not permission sessions:revoke") {
+		t.Errorf("other accounts must not force logout, got %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, token); acc == nil {
+		t.Fatalf("session must be kept after rejected logout")
+	}
+	if body := requestWithToken("POST", url, sToken, nil); body != `{"ok":true}` {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, token); acc != nil {
+		t.Errorf("sessions must be revoked by supervisor")
+	}
+}
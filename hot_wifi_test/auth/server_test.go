@@ -1,73 +1,154 @@
 package auth
 
 import (
-	"testing"
-	"net/http"
-	"net/http/httptest"
-	"fmt"
+	"bytes"
 	"context"
-	"go.mongodb.org/mongo-driver/mongo"
-	"os"
 	"encoding/json"
-	"bytes"
+	"fmt"
 	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 var sh *ServerHandler
 var am *AuthMiddleWare
 
-var as *AccountsStorage
-var ps *PolicyStorage
-var ss *SessionsStorage
+var as *MemoryAccountsStorage
+var ps *MemoryPolicyStorage
+var ss *MemorySessionsStorage
+var rs *MemoryRefreshTokensStorage
+var rls *MemoryRolesStorage
+var ls *MemoryLockoutsStorage
+var aus *MemoryAuditStorage
 
-var db *mongo.Database
+var cfg *Config
+var hashing *PasswordHashing
 
 var sToken string
 var router *mux.Router
+var tokens *TokenIssuer
+
+// newTestConfig returns valid config which does not need any environment
+func newTestConfig() *Config {
+	config := DefaultConfig()
+	config.Storage = "memory"
+	config.Supervisor = SupervisorConfig{Login: "root", Password: "root"}
+	// tests login many times from one address
+	config.Lockout.RateLimit = 0
+	return config
+}
 
 func setUp() {
-	DB_NAME = fmt.Sprintf("%s_test", DB_NAME)
-	db, _ = InitDb()
-	db.Drop(context.TODO())
+	ctx := context.Background()
+	cfg = newTestConfig()
+	hashing = cfg.Hashing()
+	as = NewMemoryAccountsStorage()
+	ps = NewMemoryPolicyStorage()
+	ss = NewMemorySessionStorage(time.Duration(cfg.SessionTTL) * time.Second)
+	rs = NewMemoryRefreshTokensStorage()
+	rls = NewMemoryRolesStorage()
+	ls = NewMemoryLockoutsStorage(time.Duration(cfg.Lockout.Reset) * time.Second)
+	aus = NewMemoryAuditStorage()
 
-	as, _ = NewAccountsStorage()
-	ps, _ = NewPolicyStorage()
-	ss, _ = NewSessionStorage()
+	key, _ := GenerateSigningKey("HS256")
+	tokens = cfg.TokenIssuer(NewKeyRing(key))
 
-	if as == nil || ps == nil || ss == nil {
-		panic("Can not connect to some storage")
-	}
-	authManager := &AuthManager{sessionsStorage: ss, accountsStorage: as}
-	sh = &ServerHandler{accountsStorage: as, policyStorage: ps, authManager: authManager}
+	authManager := NewAuthManager(cfg, ss, rs, as, rls, tokens)
+	sh = &ServerHandler{config: cfg, hashing: hashing, accountsStorage: as, policyStorage: ps, rolesStorage: rls, lockoutsStorage: ls, auditStorage: aus, metrics: NewMetrics(), authManager: authManager}
 	am = &AuthMiddleWare{manager: authManager}
 
-	sAcc := PrepareSupervisor(as)
-	session, _ := authManager.Login(sAcc)
+	sAcc := PrepareSupervisor(ctx, cfg, as)
+	session, _ := authManager.Login(ctx, sAcc, "test", "127.0.0.1")
 	sToken = session.Token
 
-	router = Router(as, ps, ss)
+	router = Router(cfg, as, ps, ss, rs, rls, ls, aus, tokens)
 }
 
+// execResp serves request by router, requests with body are sent as JSON unless they say otherwise.
 func execResp(req *http.Request) *httptest.ResponseRecorder {
+	if req.Body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
 	return rr
 }
 
-func tearDown() {
-	db.Drop(context.TODO())
+// problemBody returns problem which WriteError answers with.
+func problemBody(status int, code, detail string) string {
+	data, _ := json.Marshal(&Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail, Code: code})
+	return string(data)
+}
+
+// prepareAccount stores account with password directly in storage, password of existing
+// account is replaced
+func prepareAccount(login, password string) *Account {
+	ctx := context.Background()
+	acc, _ := as.GetAccount(ctx, login)
+	if acc == nil {
+		acc = &Account{Login: login}
+		acc.SetNewPassword(hashing, password)
+		as.CreateAccount(ctx, acc)
+	} else {
+		acc.SetNewPassword(hashing, password)
+		as.UpdateAccount(ctx, acc)
+	}
+	stored, _ := as.GetAccount(ctx, login)
+	return stored
+}
+
+// accountETag returns ETag of account as it is stored now
+func accountETag(acc *Account) string {
+	stored, _ := as.GetAccountById(context.Background(), acc.ID.Hex())
+	return etag(stored.Version)
+}
+
+// setTestPolicy replaces stored policy whatever its version is
+func setTestPolicy(p *PasswordPolicy) {
+	ctx := context.Background()
+	current, _ := ps.GetPolicy(ctx)
+	policy := *p
+	policy.Version = current.Version
+	ps.SetPolicy(ctx, &policy)
+}
+
+// loginAs logins with password through api and returns access token
+func loginAs(login, password string, headers ...string) string {
+	return loginResponseAs(login, password, headers...).Token
+}
+
+func loginResponseAs(login, password string, headers ...string) *LoginResponse {
+	data, _ := json.Marshal(&LoginData{Login: login, Password: password})
+	req, _ := http.NewRequest("POST", "/api/accounts/login", bytes.NewBuffer(data))
+	req.RemoteAddr = "127.0.0.1:12345"
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rr := execResp(req)
+	var resp LoginResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return &resp
 }
 
 func TestMain(m *testing.M) {
 	setUp()
 	code := m.Run()
-	tearDown()
 	os.Exit(code)
 }
 
 func TestAccounts(t *testing.T) {
+	ctx := context.Background()
+	// listing expects only supervisor, other tests may have created accounts
+	setUp()
+
 	req, err := http.NewRequest("GET", "/accounts", nil)
-	req.Header.Set(HEADER_NAME, sToken)
+	req.Header.Set(cfg.HeaderName, sToken)
 
 	if err != nil {
 		t.Fatal(err)
@@ -80,9 +161,9 @@ func TestAccounts(t *testing.T) {
 			rr.Code, 200)
 	}
 
-	acc, _ := as.GetAccount(SUPERVISOR_LOGIN)
+	acc, _ := as.GetAccount(ctx, cfg.Supervisor.Login)
 
-	expected := fmt.Sprintf(`[{"id":"%s","login":"%s","isExternalAccount":false}]`, acc.ID.Hex(), SUPERVISOR_LOGIN)
+	expected := fmt.Sprintf(`[{"id":"%s","login":"%s","isExternalAccount":false}]`, acc.ID.Hex(), cfg.Supervisor.Login)
 	if rr.Body.String() != expected {
 		t.Errorf("unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -90,18 +171,19 @@ func TestAccounts(t *testing.T) {
 }
 
 func TestAccountCycle(t *testing.T) {
-	acc := Account{Login: "test", Password: "testTEST123", IsExternalAccount: false}
+	ctx := context.Background()
+	acc := AccountCreateData{Login: "test", Password: "testTEST123", IsExternalAccount: false}
 
 	data, _ := json.Marshal(&acc)
 	req, _ := http.NewRequest("POST", "/accounts", bytes.NewBuffer(data))
-	req.Header.Set(HEADER_NAME, sToken)
+	req.Header.Set(cfg.HeaderName, sToken)
 	rr := execResp(req)
 
 	if rr.Code != 200 {
 		t.Errorf("wrong status code: got %v want %v",
 			rr.Code, 200)
 	}
-	storedAcc, _ := as.GetAccount("test")
+	storedAcc, _ := as.GetAccount(ctx, "test")
 	expected := fmt.Sprintf(`{"ok":true,"id":"%s"}`, storedAcc.ID.Hex())
 	if rr.Body.String() != expected {
 		t.Errorf("unexpected body: got %v want %v",
@@ -116,16 +198,28 @@ func TestAccountCycle(t *testing.T) {
 		t.Errorf("wrong status code: got %v want %v",
 			rr.Code, 200)
 	}
-	sess, _ := ss.GetSessionByLogin("test")
-	expected = fmt.Sprintf(`{"ok":true,"auth-token":"%s"}`, sess.Token)
-	if rr.Body.String() != expected {
-		t.Errorf("unexpected body: got %v want %v",
-			rr.Body.String(), expected)
+	var loginResp LoginResponse
+	json.Unmarshal(rr.Body.Bytes(), &loginResp)
+	sess := Session{Token: loginResp.Token}
+	if !loginResp.OK {
+		t.Errorf("unexpected body: %v", rr.Body.String())
+	}
+	if acc, _ := sh.authManager.FromToken(ctx, sess.Token); acc == nil || acc.Login != "test" {
+		t.Errorf("token must be valid for test, got %v", acc)
 	}
 
 	data, _ = json.Marshal(&ChangePasswordData{Old: "testTEST123", New: "tT1o0"})
 	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/accounts/%s/password", storedAcc.ID.Hex()), bytes.NewBuffer(data))
-	req.Header.Set(HEADER_NAME, sess.Token)
+	req.Header.Set(cfg.HeaderName, sess.Token)
+	if rr = execResp(req); rr.Code != 428 {
+		t.Errorf("change without If-Match must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+	req, _ = http.NewRequest("GET", "/api/accounts/sessions", nil)
+	req.Header.Set(cfg.HeaderName, sess.Token)
+	etag := execResp(req).Header().Get("ETag")
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/accounts/%s/password", storedAcc.ID.Hex()), bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, sess.Token)
+	req.Header.Set("If-Match", etag)
 	rr = execResp(req)
 
 	if rr.Code != 200 {
@@ -139,7 +233,7 @@ func TestAccountCycle(t *testing.T) {
 	}
 
 	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/accounts/%s", storedAcc.ID.Hex()), nil)
-	req.Header.Set(HEADER_NAME, sess.Token)
+	req.Header.Set(cfg.HeaderName, sess.Token)
 	rr = execResp(req)
 
 	if rr.Code != 200 {
@@ -153,3 +247,207 @@ func TestAccountCycle(t *testing.T) {
 
 	}
 }
+
+func TestLoginChecksPassword(t *testing.T) {
+	ctx := context.Background()
+	acc := Account{Login: "checked"}
+	acc.SetNewPassword(hashing, "goodPASS1")
+	as.CreateAccount(ctx, &acc)
+
+	data, _ := json.Marshal(&LoginData{Login: "checked", Password: "badPASS1"})
+	req, _ := http.NewRequest("POST", "/api/accounts/login", bytes.NewBuffer(data))
+	rr := execResp(req)
+
+	expected := problemBody(401, "bad_credentials", "Bad login or password")
+	if rr.Body.String() != expected {
+		t.Errorf("unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+	if sessions, _ := ss.GetSessions(ctx, "checked"); len(sessions) != 0 {
+		t.Errorf("session must not be created with bad password")
+	}
+}
+
+func TestLoginUpgradesLegacyHash(t *testing.T) {
+	ctx := context.Background()
+	legacy := "e5e9fa1ba31ecd1ae84f75caaa474f3a663f05f4" // sha1("secret")
+	as.CreateAccount(ctx, &Account{Login: "legacy", PasswordHash: legacy, PasswordCreated: time.Now().Unix()})
+
+	data, _ := json.Marshal(&LoginData{Login: "legacy", Password: "secret"})
+	req, _ := http.NewRequest("POST", "/api/accounts/login", bytes.NewBuffer(data))
+	rr := execResp(req)
+
+	if sessions, _ := ss.GetSessions(ctx, "legacy"); len(sessions) != 1 {
+		t.Fatalf("session must be created, got %v", rr.Body.String())
+	}
+	acc, _ := as.GetAccount(ctx, "legacy")
+	if !hashing.Current.Supports(acc.PasswordHash) {
+		t.Errorf("legacy hash must be upgraded, got %v", acc.PasswordHash)
+	}
+	if ok, _, _ := acc.CheckPassword(hashing, "secret"); !ok {
+		t.Errorf("upgraded hash must match password")
+	}
+}
+
+func TestNoCleartextPasswords(t *testing.T) {
+	ctx := context.Background()
+	secrets := []string{"firstSECRET1", "secondSECRET2"}
+	responses := []string{}
+
+	data, _ := json.Marshal(&AccountCreateData{Login: "secretive", Password: secrets[0]})
+	req, _ := http.NewRequest("POST", "/accounts", bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, sToken)
+	rr := execResp(req)
+	responses = append(responses, rr.Body.String())
+
+	data, _ = json.Marshal(&LoginData{Login: "secretive", Password: secrets[0]})
+	req, _ = http.NewRequest("POST", "/api/accounts/login", bytes.NewBuffer(data))
+	rr = execResp(req)
+	responses = append(responses, rr.Body.String())
+
+	var loginResp LoginResponse
+	json.Unmarshal(rr.Body.Bytes(), &loginResp)
+	acc, _ := as.GetAccount(ctx, "secretive")
+
+	data, _ = json.Marshal(&ChangePasswordData{Old: secrets[0], New: secrets[1]})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/accounts/%s/password", acc.ID.Hex()), bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, loginResp.Token)
+	req.Header.Set("If-Match", accountETag(acc))
+	rr = execResp(req)
+	responses = append(responses, rr.Body.String())
+
+	req, _ = http.NewRequest("GET", "/accounts", nil)
+	req.Header.Set(cfg.HeaderName, sToken)
+	rr = execResp(req)
+	responses = append(responses, rr.Body.String())
+
+	acc, _ = as.GetAccount(ctx, "secretive")
+	stored, _ := bson.Marshal(acc)
+	for _, secret := range secrets {
+		for _, response := range responses {
+			if strings.Contains(response, secret) {
+				t.Errorf("response has cleartext password: %s", response)
+			}
+		}
+		if bytes.Contains(stored, []byte(secret)) {
+			t.Errorf("stored account has cleartext password")
+		}
+	}
+	if ok, _, _ := acc.CheckPassword(hashing, secrets[1]); !ok {
+		t.Errorf("password must be changed")
+	}
+}
+
+func changeExpired(login, old, new string) string {
+	data, _ := json.Marshal(&ExpiredPasswordChangeData{Login: login, Old: old, New: new})
+	req, _ := http.NewRequest("POST", "/api/accounts/password/change-expired", bytes.NewBuffer(data))
+	return execResp(req).Body.String()
+}
+
+func TestExpiredPasswordChange(t *testing.T) {
+	ctx := context.Background()
+	acc := prepareAccount("expired", "expiredPASS1")
+	acc.PasswordCreated = time.Now().Unix() - int64(cfg.PasswordTTL) - 1
+	as.UpdateAccount(ctx, acc)
+
+	data, _ := json.Marshal(&LoginData{Login: "expired", Password: "expiredPASS1"})
+	req, _ := http.NewRequest("POST", "/api/accounts/login", bytes.NewBuffer(data))
+	rr := execResp(req)
+	expected := problemBody(403, "password_expired", ErrPasswordExpired.Message)
+	if rr.Body.String() != expected {
+		t.Errorf("unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	if body := changeExpired("expired", "wrongPASS1", "renewedPASS1"); body != problemBody(401, "bad_credentials", "Bad login or password") {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if body := changeExpired("expired", "expiredPASS1", "expiredPASS1"); body != problemBody(400, "password_not_changed", "New password must differ from expired one") {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if body := changeExpired("expired", "expiredPASS1", "renewedPASS1"); body != `{"ok":true}` {
+		t.Errorf("unexpected body: %v", body)
+	}
+	if token := loginAs("expired", "renewedPASS1"); token == "" {
+		t.Errorf("login with new password must succeed")
+	}
+	if body := changeExpired("expired", "renewedPASS1", "anotherPASS1"); body != problemBody(400, "password_not_expired", "Password is not expired, change it at /api/accounts/{id}/password") {
+		t.Errorf("not expired password must not be changed without session, got %v", body)
+	}
+}
+
+func createAccountReq(data AccountCreateData, key string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(&data)
+	req, _ := http.NewRequest("POST", "/accounts", bytes.NewBuffer(body))
+	req.Header.Set(cfg.HeaderName, sToken)
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	return execResp(req)
+}
+
+func TestCreateAccountIsInsertOnly(t *testing.T) {
+	ctx := context.Background()
+	if rr := createAccountReq(AccountCreateData{Login: "Inserted", Password: "firstPASS1"}, ""); rr.Code != 200 {
+		t.Fatalf("account must be created, got %v %v", rr.Code, rr.Body.String())
+	}
+	acc, _ := as.GetAccount(ctx, "inserted")
+	if acc == nil {
+		t.Fatalf("login must be stored in lower case")
+	}
+	rr := createAccountReq(AccountCreateData{Login: "inserted ", Password: "secondPASS1"}, "")
+	if rr.Code != 409 || rr.Body.String() != problemBody(409, "login_taken", ErrLoginAlreadyExists.Message) {
+		t.Errorf("taken login must be conflict, got %v %v", rr.Code, rr.Body.String())
+	}
+	if token := loginAs("INSERTED", "firstPASS1"); token == "" {
+		t.Errorf("existing account must keep its password and be found by any case of login")
+	}
+
+	rr = createAccountReq(AccountCreateData{Login: "bad login!", Password: "firstPASS1"}, "")
+	var problem Problem
+	json.Unmarshal(rr.Body.Bytes(), &problem)
+	if rr.Code != 400 || len(problem.Errors) != 1 || problem.Errors[0].Field != "login" {
+		t.Errorf("login with bad characters must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCreateAccountDoesNotShadowSupervisor(t *testing.T) {
+	ctx := context.Background()
+	config := *cfg
+	config.Supervisor.Login = "Boss"
+	accounts := NewMemoryAccountsStorage()
+	boss := PrepareSupervisor(ctx, &config, accounts)
+	if !config.IsSupervisor(&Account{Login: "boss"}) {
+		t.Errorf("supervisor must be found by normalised login")
+	}
+	session, _ := NewAuthManager(&config, ss, rs, accounts, rls, tokens).Login(ctx, boss, "test", "127.0.0.1")
+
+	data, _ := json.Marshal(&AccountCreateData{Login: "boss", Password: "shadowPASS1"})
+	req, _ := http.NewRequest("POST", "/accounts", bytes.NewBuffer(data))
+	req.Header.Set(cfg.HeaderName, session.Token)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	Router(&config, accounts, ps, ss, rs, rls, ls, aus, tokens).ServeHTTP(rr, req)
+	if rr.Body.String() != problemBody(409, "login_taken", ErrLoginAlreadyExists.Message) {
+		t.Errorf("login of supervisor must be taken, got %v %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCreateAccountIdempotencyKey(t *testing.T) {
+	data := AccountCreateData{Login: "retried", Password: "retriedPASS1"}
+	first := createAccountReq(data, "create-retried")
+	retry := createAccountReq(data, "create-retried")
+	if first.Code != 200 || retry.Code != 200 || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry must answer with the same account, got %v and %v", first.Body.String(), retry.Body.String())
+	}
+	changed := createAccountReq(AccountCreateData{Login: "retried", Password: "changedPASS1"}, "create-retried")
+	if changed.Body.String() != problemBody(409, "idempotency_key_reused", ErrIdempotencyKeyReused.Message) {
+		t.Errorf("retry with other body must be rejected, got %v %v", changed.Code, changed.Body.String())
+	}
+	if rr := createAccountReq(data, "other-key"); rr.Code != 409 {
+		t.Errorf("other key must not get existing account, got %v %v", rr.Code, rr.Body.String())
+	}
+	rr := createAccountReq(AccountCreateData{Login: "another", Password: "anotherPASS1"}, "create-retried")
+	if rr.Body.String() != problemBody(409, "idempotency_key_reused", ErrIdempotencyKeyReused.Message) {
+		t.Errorf("key of other account must be rejected, got %v %v", rr.Code, rr.Body.String())
+	}
+}
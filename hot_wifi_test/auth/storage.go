@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// Pinger is storage which may check that its backend is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+var ErrAccountNotFound = NewError(KindNotFound, "account_not_found", "Account not found")
+var ErrLoginAlreadyExists = NewError(KindConflict, "login_taken", "Account with this login already exists")
+var ErrVersionConflict = NewError(KindPreconditionFailed, "version_conflict", "Record was changed by another request, read it again")
+var ErrIdempotencyKeyReused = NewError(KindConflict, "idempotency_key_reused", "Idempotency key was used for another request")
+
+type AccountsStorage interface {
+	// CreateAccount inserts new account and sets its id. It returns ErrLoginAlreadyExists if
+	// login is taken and ErrIdempotencyKeyReused if other account was created with same key.
+	CreateAccount(ctx context.Context, account *Account) error
+	GetAccount(ctx context.Context, login string) (*Account, error)
+	GetAccountById(ctx context.Context, id string) (*Account, error)
+	GetAccountsViews(ctx context.Context) ([]AccountView, error)
+	// UpdateAccount replaces account with same id and version and increments version. It
+	// returns ErrAccountNotFound if there is no such account, ErrVersionConflict if stored
+	// version differs and ErrLoginAlreadyExists if new login is taken.
+	UpdateAccount(ctx context.Context, account *Account) error
+	DeleteAccount(ctx context.Context, id string) error
+}
+
+// SessionsStorage keeps any number of independent sessions per login.
+type SessionsStorage interface {
+	SetSession(ctx context.Context, session *Session) error
+	GetSession(ctx context.Context, id string) (*Session, error)
+	GetSessions(ctx context.Context, login string) ([]Session, error)
+	TouchSession(ctx context.Context, id string, lastSeen time.Time) error
+	DeleteSession(ctx context.Context, id string) error
+	DeleteSessions(ctx context.Context, login string) error
+	// CountSessions returns count of alive sessions of all logins.
+	CountSessions(ctx context.Context) (int, error)
+}
+
+type PolicyStorage interface {
+	// SetPolicy replaces policy if stored one has same version and increments version, not
+	// stored policy has version 0. It returns ErrVersionConflict if versions differ.
+	SetPolicy(ctx context.Context, p *PasswordPolicy) error
+	GetPolicy(ctx context.Context) (*PasswordPolicy, error)
+}
+
+// RefreshTokensStorage keeps hashes of refresh tokens. Tokens of one session make a family,
+// family id is id of session.
+type RefreshTokensStorage interface {
+	SetRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error)
+	// UseRefreshToken marks token as used. It returns false if token was used already.
+	UseRefreshToken(ctx context.Context, hash string) (bool, error)
+	DeleteRefreshTokens(ctx context.Context, family string) error
+}
+
+type RolesStorage interface {
+	SetRole(ctx context.Context, role *Role) error
+	// GetRole returns ErrRoleNotFound if there is no role with name.
+	GetRole(ctx context.Context, name string) (*Role, error)
+	GetRoles(ctx context.Context) ([]Role, error)
+	DeleteRole(ctx context.Context, name string) error
+}
+
+// LockoutsStorage keeps failed login counters, counters expire after lockout reset of config
+// seconds without failures.
+type LockoutsStorage interface {
+	// AddFailure increments failures of login and returns updated lockout.
+	AddFailure(ctx context.Context, login string, at time.Time) (*Lockout, error)
+	SetLockedUntil(ctx context.Context, login string, until time.Time) error
+	GetLockout(ctx context.Context, login string) (*Lockout, error)
+	GetLockouts(ctx context.Context) ([]Lockout, error)
+	DeleteLockout(ctx context.Context, login string) error
+}
+
+// AuditStorage is append only store of audit events.
+type AuditStorage interface {
+	AddEvent(ctx context.Context, event *AuditEvent) error
+	FindEvents(ctx context.Context, filter *AuditFilter) ([]AuditEvent, error)
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret of RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPVectors(t *testing.T) {
+	vectors := map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"}
+	for ts, expected := range vectors {
+		code, err := totpCode(rfcSecret, ts/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("code at %v: got %v want %v", ts, code, expected)
+		}
+	}
+}
+
+func TestTOTPValidation(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step, ok := validateTOTP(rfcSecret, "081804", now, 0)
+	if !ok {
+		t.Fatalf("current code must be valid")
+	}
+	if _, ok := validateTOTP(rfcSecret, "081804", now, step); ok {
+		t.Errorf("code must not be accepted twice")
+	}
+	previous, _ := totpCode(rfcSecret, step-1)
+	if _, ok := validateTOTP(rfcSecret, previous, now, 0); !ok {
+		t.Errorf("code of previous step must be accepted")
+	}
+	old, _ := totpCode(rfcSecret, step-2)
+	if _, ok := validateTOTP(rfcSecret, old, now, 0); ok {
+		t.Errorf("old code must be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("hot wifi", "user@example.com", rfcSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/hot%20wifi:user@example.com?") || !strings.Contains(uri, "secret="+rfcSecret) {
+		t.Errorf("unexpected uri: %v", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc := Account{TOTPEnabled: true, TOTPSecret: rfcSecret, RecoveryCodes: hashes}
+	for _, hash := range hashes {
+		for _, code := range codes {
+			if hash == code {
+				t.Errorf("recovery codes must be stored hashed")
+			}
+		}
+	}
+	if !acc.VerifySecondFactor(strings.ToUpper(codes[0]), time.Now()) {
+		t.Errorf("recovery code must be accepted")
+	}
+	if acc.VerifySecondFactor(codes[0], time.Now()) {
+		t.Errorf("recovery code must be accepted once")
+	}
+	if len(acc.RecoveryCodes) != len(codes)-1 {
+		t.Errorf("used recovery code must be removed")
+	}
+}
@@ -18,23 +18,107 @@ func panicConnectionErr(err error) {
 	}
 }
 
+// initSessionStorage returns redis sessions storage if it is configured or default one
+func initSessionStorage(config *auth.Config, defaultStorage func() auth.SessionsStorage) auth.SessionsStorage {
+	if config.SessionStorage != "redis" {
+		return defaultStorage()
+	}
+	log.Printf("Using redis at %s for sessions", config.Redis.Addr)
+	client, err := auth.InitRedis(&config.Redis)
+	panicConnectionErr(err)
+	return auth.NewRedisSessionStorage(client, time.Duration(config.SessionTTL)*time.Second)
+}
 
+// initStorages returns in-memory storages or mongo ones which share client of db
+func initStorages(config *auth.Config, db *auth.Mongo) (auth.AccountsStorage, auth.PolicyStorage, auth.SessionsStorage, auth.RefreshTokensStorage, auth.RolesStorage, auth.LockoutsStorage, auth.AuditStorage) {
+	if config.Storage == "memory" {
+		log.Println("Using in-memory storages, all data will be lost at exit")
+		sessionStorage := initSessionStorage(config, func() auth.SessionsStorage {
+			return auth.NewMemorySessionStorage(time.Duration(config.SessionTTL) * time.Second)
+		})
+		return auth.NewMemoryAccountsStorage(), auth.NewMemoryPolicyStorage(), sessionStorage, auth.NewMemoryRefreshTokensStorage(), auth.NewMemoryRolesStorage(),
+			auth.NewMemoryLockoutsStorage(time.Duration(config.Lockout.Reset) * time.Second), auth.NewMemoryAuditStorage()
+	}
 
-func main() {
-	accountsStorage, err := auth.NewAccountsStorage()
+	panicConnectionErr(auth.Migrate(context.Background(), db.DB))
+
+	accountsStorage, err := auth.NewMongoAccountsStorage(db.DB)
+	panicConnectionErr(err)
+
+	policyStorage, err := auth.NewMongoPolicyStorage(db.DB)
 	panicConnectionErr(err)
-	auth.PrepareSupervisor(accountsStorage)
 
-	sessionStorage, err := auth.NewSessionStorage()
+	sessionStorage := initSessionStorage(config, func() auth.SessionsStorage {
+		sessionStorage, err := auth.NewMongoSessionStorage(db.DB, time.Duration(config.SessionTTL)*time.Second)
+		panicConnectionErr(err)
+		return sessionStorage
+	})
+
+	refreshTokensStorage, err := auth.NewMongoRefreshTokensStorage(db.DB)
 	panicConnectionErr(err)
 
-	policyStorage, err := auth.NewPolicyStorage()
+	rolesStorage, err := auth.NewMongoRolesStorage(db.DB)
 	panicConnectionErr(err)
 
-	router := auth.Router(accountsStorage, policyStorage, sessionStorage)
+	lockoutsStorage, err := auth.NewMongoLockoutsStorage(db.DB, time.Duration(config.Lockout.Reset)*time.Second)
+	panicConnectionErr(err)
+
+	auditStorage, err := auth.NewMongoAuditStorage(db.DB)
+	panicConnectionErr(err)
+
+	return accountsStorage, policyStorage, sessionStorage, refreshTokensStorage, rolesStorage, lockoutsStorage, auditStorage
+}
+
+func initTokens(config *auth.Config, done <-chan struct{}) *auth.TokenIssuer {
+	keys, err := auth.NewKeyRingFromEnv(config.JWT.Alg, config.JWT.Keys)
+	if err != nil {
+		panic(err)
+	}
+	tokens := config.TokenIssuer(keys)
+	if config.JWT.RotationPeriod > 0 {
+		go keys.AutoRotate(time.Duration(config.JWT.RotationPeriod)*time.Second, tokens.TTL, done)
+	}
+	return tokens
+}
+
+func initProviders(config *auth.Config) []auth.IdentityProvider {
+	providers := []auth.IdentityProvider{}
+	if config.LDAP.URL != "" {
+		log.Printf("Using ldap at %s for external accounts", config.LDAP.URL)
+		providers = append(providers, auth.NewLDAPProvider(config.LDAP.ProviderName, config.LDAP.URL, config.LDAP.UserDN))
+	}
+	if config.OIDC.Issuer != "" {
+		log.Printf("Using OpenID Connect issuer %s for external accounts", config.OIDC.Issuer)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		provider, err := auth.NewOIDCProvider(ctx, config.OIDC.ProviderName, config.OIDC.Issuer, config.OIDC.ClientID, config.OIDC.ClientSecret, config.OIDC.RedirectURL)
+		panicConnectionErr(err)
+		provider.LoginClaim = config.OIDC.LoginClaim
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+func main() {
+	config, err := auth.LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Bad configuration:\n%s", err)
+	}
+	done := make(chan struct{})
+
+	var db *auth.Mongo
+	if config.Storage != "memory" {
+		db, err = auth.ConnectMongo(&config.Mongo)
+		panicConnectionErr(err)
+	}
+
+	accountsStorage, policyStorage, sessionStorage, refreshTokensStorage, rolesStorage, lockoutsStorage, auditStorage := initStorages(config, db)
+	auth.PrepareSupervisor(context.Background(), config, accountsStorage)
+
+	router := auth.Router(config, accountsStorage, policyStorage, sessionStorage, refreshTokensStorage, rolesStorage, lockoutsStorage, auditStorage, initTokens(config, done), initProviders(config)...)
 
 	srv := &http.Server{
-		Addr: fmt.Sprintf("%s:%v", auth.HOST, auth.PORT),
+		Addr:         fmt.Sprintf("%s:%v", config.Host, config.Port),
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
@@ -51,9 +135,15 @@ func main() {
 	signal.Notify(c, os.Interrupt)
 
 	<-c
+	close(done)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
 	srv.Shutdown(ctx)
+	if db != nil {
+		if err := db.Close(ctx); err != nil {
+			log.Printf("Error at close mongo: %s", err)
+		}
+	}
 	os.Exit(0)
 }